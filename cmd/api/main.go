@@ -1,7 +1,37 @@
 package main
 
-import "github.com/IrinaChuprakova/mock-api/internal/app"
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	// The runtime image has no zoneinfo; ?tz= on orders needs it.
+	_ "time/tzdata"
 
+	"github.com/IrinaChuprakova/mock-api/internal/app"
+)
+
+// Usage: api [flags] runs the API; api gc [flags] removes unused images once and exits.
 func main() {
-	app.Run()
+	args, gc := os.Args[1:], false
+	if len(args) > 0 && args[0] == "gc" {
+		args, gc = args[1:], true
+	}
+
+	cfg, err := app.LoadConfig(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
+
+	if gc {
+		if err = app.CollectImages(cfg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	app.Run(cfg)
 }
@@ -1,5 +1,5 @@
-// Package docs GENERATED BY SWAG; DO NOT EDIT
-// This file was generated by swaggo/swag
+// Code generated by swaggo/swag. DO NOT EDIT.
+
 package docs
 
 import "github.com/swaggo/swag"
@@ -16,38 +16,22 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/cards": {
-            "get": {
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "cards"
-                ],
-                "summary": "Получить массив карточек",
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/app.Card"
-                            }
-                        }
-                    }
-                }
-            },
+        "/api/auth/login": {
             "post": {
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "cards"
+                    "auth"
                 ],
-                "summary": "Создать карточку",
+                "summary": "Войти по email и паролю",
                 "parameters": [
                     {
                         "description": "body",
@@ -55,7 +39,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/app.CardRequest"
+                            "$ref": "#/definitions/app.credentialsRequest"
                         }
                     }
                 ],
@@ -63,44 +47,77 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/app.Card"
+                            "$ref": "#/definitions/app.authResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "некорректный JSON",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
                         }
                     }
                 }
             }
         },
-        "/api/cards/cart": {
+        "/api/auth/me": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "cart"
+                    "auth"
                 ],
-                "summary": "Получить массив карточек из корзины",
+                "summary": "Текущий пользователь",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/app.Card"
-                            }
+                            "$ref": "#/definitions/app.User"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/auth/refresh": {
             "post": {
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "cart"
+                    "auth"
                 ],
-                "summary": "добавить карточку в корзину",
+                "summary": "Обновить пару токенов по refresh-токену",
                 "parameters": [
                     {
                         "description": "body",
@@ -108,7 +125,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/app.Card"
+                            "$ref": "#/definitions/app.refreshRequest"
                         }
                     }
                 ],
@@ -116,43 +133,141 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/app.Card"
+                            "$ref": "#/definitions/app.Tokens"
+                        }
+                    },
+                    "400": {
+                        "description": "некорректный JSON",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
                         }
                     }
                 }
             }
         },
-        "/api/cards/cart/{id}": {
-            "delete": {
+        "/api/auth/register": {
+            "post": {
+                "description": "Новые пользователи получают роль customer, адреса из настройки admin_emails — роль admin.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
                 "tags": [
-                    "cart"
+                    "auth"
                 ],
-                "summary": "удалить карточку из корзины",
+                "summary": "Зарегистрировать пользователя",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.credentialsRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/app.authResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
                     }
                 }
             }
         },
-        "/api/cards/favorite": {
+        "/api/cards": {
             "get": {
+                "description": "Общее число найденных карточек возвращается в заголовке X-Total-Count.\nЕсли есть следующая страница, курсор на неё передаётся в заголовках X-Next-Cursor и Link.",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "favorite"
+                    "cards"
+                ],
+                "summary": "Получить массив карточек",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "подстрока названия (без учёта регистра)",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "минимальная цена",
+                        "name": "min_price",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "максимальная цена",
+                        "name": "max_price",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "поля сортировки через запятую, '-' — по убыванию: price,-name",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "размер страницы (1-1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "сколько карточек пропустить",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "курсор из X-Next-Cursor предыдущей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Получить массив карточек из избранного",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -161,21 +276,46 @@ const docTemplate = `{
                             "items": {
                                 "$ref": "#/definitions/app.Card"
                             }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "курсор следующей страницы"
+                            },
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "число карточек, подходящих под фильтры"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
                         }
                     }
                 }
             },
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "favorite"
+                    "cards"
                 ],
-                "summary": "добавить карточку в избранное",
+                "summary": "Создать карточку",
                 "parameters": [
                     {
                         "description": "body",
@@ -183,7 +323,7 @@ const docTemplate = `{
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/app.Card"
+                            "$ref": "#/definitions/app.CardRequest"
                         }
                     }
                 ],
@@ -193,161 +333,1804 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/app.Card"
                         }
+                    },
+                    "400": {
+                        "description": "некорректный JSON",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
                     }
                 }
             }
         },
-        "/api/cards/favorite/{id}": {
-            "delete": {
+        "/api/cards/cart": {
+            "get": {
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
                 "tags": [
-                    "favorite"
+                    "cart"
                 ],
-                "summary": "удалить карточку из избранного",
+                "summary": "Получить корзину",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "204": {
-                        "description": "No Content"
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
                     }
-                }
-            }
-        },
-        "/api/cards/order": {
-            "get": {
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "order"
                 ],
-                "summary": "Получить массив карточек заказов",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/app.orderResponse"
-                            }
+                            "$ref": "#/definitions/app.CartSummary"
                         }
                     }
                 }
             },
             "post": {
+                "description": "Карточка и её цена берутся из каталога по id. Если карточка уже в корзине, её количество увеличивается.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "order"
+                    "cart"
                 ],
-                "summary": "добавить карточку в список заказов",
+                "summary": "добавить карточку в корзину",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
                     {
                         "description": "body",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/app.orderRequest"
+                            "$ref": "#/definitions/app.cartItemRequest"
                         }
                     }
                 ],
                 "responses": {
                     "201": {
-                        "description": "Created"
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/app.CartItem"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "карточки нет в каталоге или превышено количество или поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "tags": [
+                    "cart"
+                ],
+                "summary": "очистить корзину",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
                     }
                 }
             }
         },
-        "/api/storage": {
+        "/api/cards/cart/checkout": {
             "post": {
-                "consumes": [
-                    "multipart/form-data"
-                ],
+                "description": "Создаёт заказ из всех позиций корзины по их текущим ценам и очищает корзину — атомарно.",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/msgpack"
                 ],
                 "tags": [
-                    "storage"
+                    "cart"
                 ],
-                "summary": "Загрузить картинку",
+                "summary": "оформить заказ из корзины",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "file",
-                        "name": "file",
-                        "in": "formData",
-                        "required": true
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/app.imageResponse"
+                            "$ref": "#/definitions/app.Order"
+                        }
+                    },
+                    "409": {
+                        "description": "корзина пуста",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "app.Card": {
-            "type": "object",
-            "properties": {
-                "id": {
-                    "type": "string"
-                },
-                "img": {
-                    "type": "string"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "price": {
-                    "type": "number"
-                }
-            }
         },
-        "app.CardRequest": {
-            "type": "object",
-            "properties": {
-                "img": {
-                    "type": "string"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "price": {
-                    "type": "number"
+        "/api/cards/cart/{id}": {
+            "delete": {
+                "tags": [
+                    "cart"
+                ],
+                "summary": "удалить карточку из корзины",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
                 }
-            }
-        },
+            },
+            "patch": {
+                "description": "Передайте либо delta (например 1 или -1), либо quantity. Позиция с количеством 0 удаляется из корзины.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "cart"
+                ],
+                "summary": "изменить количество карточки в корзине",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.cartQuantityRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.CartSummary"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/cards/favorite": {
+            "get": {
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "favorite"
+                ],
+                "summary": "Получить массив карточек из избранного",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/app.Card"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Карточка берётся из каталога по id.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "favorite"
+                ],
+                "summary": "добавить карточку в избранное",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.favoriteRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Card"
+                        }
+                    },
+                    "400": {
+                        "description": "некорректный JSON",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "карточки нет в каталоге или поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/cards/favorite/{id}": {
+            "delete": {
+                "tags": [
+                    "favorite"
+                ],
+                "summary": "удалить карточку из избранного",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            }
+        },
+        "/api/cards/order": {
+            "get": {
+                "description": "Заказы группируются по дню, неделе (с понедельника) или месяцу создания в часовом поясе tz;\nгруппы и заказы внутри них идут от новых к старым.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Получить массив карточек заказов",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "default": "UTC",
+                        "description": "часовой пояс IANA, например Europe/Moscow",
+                        "name": "tz",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "day",
+                            "week",
+                            "month"
+                        ],
+                        "type": "string",
+                        "default": "day",
+                        "description": "период группировки",
+                        "name": "granularity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "заказы не раньше: дата YYYY-MM-DD (в поясе tz) или время RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "заказы раньше этого времени; дата YYYY-MM-DD включает весь день",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "статусы через запятую: pending,paid",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "минимальная сумма заказа",
+                        "name": "min_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "максимальная сумма заказа",
+                        "name": "max_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "только заказы с этой карточкой",
+                        "name": "card_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "сколько заказов вернуть (1-1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "сколько заказов пропустить",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/app.orderResponse"
+                            }
+                        },
+                        "headers": {
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "число заказов, подходящих под фильтры"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Карточки берутся из каталога по id, их цены фиксируются в заказе на момент оформления.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "добавить карточку в список заказов",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.orderRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/app.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "карточки нет в каталоге или поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/cards/search": {
+            "get": {
+                "description": "Ищет по названию карточки. Если точных совпадений нет, выполняется поиск с учётом опечаток (fuzzy=true).\nВ highlight название экранировано как HTML, совпавшие слова обёрнуты в \u003cmark\u003e.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "cards"
+                ],
+                "summary": "Полнотекстовый поиск карточек",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "поисковый запрос",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "максимум результатов (1-100, по умолчанию 20)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/app.SearchHit"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/cards/{id}": {
+            "get": {
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "cards"
+                ],
+                "summary": "Получить карточку",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Card"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Копии карточки в избранном и корзине обновляются вместе с ней.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "cards"
+                ],
+                "summary": "Заменить карточку",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.CardRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Card"
+                        }
+                    },
+                    "400": {
+                        "description": "некорректный JSON",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Карточка также удаляется из избранного и корзины; оформленные заказы не меняются.",
+                "tags": [
+                    "cards"
+                ],
+                "summary": "Удалить карточку",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Тело запроса — JSON merge patch (RFC 7396): переданные поля заменяются, null сбрасывает поле.\nКопии карточки в избранном и корзине обновляются вместе с ней.",
+                "consumes": [
+                    "application/merge-patch+json",
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "cards"
+                ],
+                "summary": "Изменить поля карточки",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.CardRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Card"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/orders/{id}": {
+            "get": {
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Получить заказ",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Order"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/orders/{id}/cancel": {
+            "post": {
+                "description": "Отменить можно заказ в статусе pending или paid.",
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Отменить заказ",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Order"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "заказ уже отправлен, доставлен или отменён",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/orders/{id}/status": {
+            "post": {
+                "description": "Допустимые переходы: pending → paid → shipped → delivered; pending и paid → cancelled.\nВладелец заказа может оплатить и отменить его, отправка и доставка доступны только администраторам.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Изменить статус заказа",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "идентификатор пользователя (иначе — cookie session_id)",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.orderStatusRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Order"
+                        }
+                    },
+                    "400": {
+                        "description": "некорректный JSON",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "переход из текущего статуса невозможен",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/storage": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Получить список загруженных картинок",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "размер страницы (1-1000)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "сколько картинок пропустить",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/app.Image"
+                            }
+                        },
+                        "headers": {
+                            "X-Total-Count": {
+                                "type": "integer",
+                                "description": "число картинок"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Файл сохраняется под именем из SHA-256 содержимого, поэтому повторная загрузка того же файла возвращает тот же id.\nТип определяется по содержимому файла; принимаются JPEG, PNG, WebP, GIF и AVIF.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Загрузить картинку",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "file",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/app.imageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "файл больше max_upload_size",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "не multipart/form-data или файл не является картинкой допустимого типа",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/storage/{id}": {
+            "get": {
+                "description": "Поддерживаются запросы диапазонов (Range) и условные запросы (If-None-Match, If-Modified-Since).\nПараметры w, h, fit и format возвращают уменьшенную или перекодированную копию, она кешируется на диске.\nfit=contain вписывает картинку в w×h, cover заполняет w×h с обрезкой по центру, fill растягивает.",
+                "produces": [
+                    "image/jpeg",
+                    "image/png",
+                    "image/webp",
+                    "image/gif",
+                    "image/avif"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Получить картинку",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ширина, до 2048",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "высота, до 2048",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "contain",
+                            "cover",
+                            "fill"
+                        ],
+                        "type": "string",
+                        "default": "contain",
+                        "description": "способ вписывания",
+                        "name": "fit",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "jpeg",
+                            "png",
+                            "gif",
+                            "webp"
+                        ],
+                        "type": "string",
+                        "description": "формат результата (по умолчанию — как у исходной картинки)",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "206": {
+                        "description": "Partial Content"
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "исходный формат нельзя перекодировать без format",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "картинку не удалось декодировать",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Картинку, которая указана в карточке, удалить нельзя.",
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Удалить картинку",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "картинка используется карточкой",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/storage/{id}/meta": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "storage"
+                ],
+                "summary": "Получить сведения о картинке",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.Image"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/users/{id}/role": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Новая роль попадает в токены пользователя при следующем входе или обновлении токенов.",
+                "consumes": [
+                    "application/json",
+                    "application/x-www-form-urlencoded",
+                    "multipart/form-data",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Назначить роль пользователю",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "id пользователя",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "body",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/app.roleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/app.User"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "поля тела не прошли проверку",
+                        "schema": {
+                            "$ref": "#/definitions/app.Problem"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "app.Card": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "img": {
+                    "description": "Img is stored as the id of an uploaded image and returned as a link to it; absolute URLs\npointing elsewhere are kept as they are.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "number"
+                }
+            }
+        },
+        "app.CardRequest": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "img": {
+                    "type": "string",
+                    "maxLength": 2048
+                },
+                "name": {
+                    "type": "string",
+                    "maxLength": 200
+                },
+                "price": {
+                    "type": "number",
+                    "maximum": 1000000,
+                    "minimum": 0
+                }
+            }
+        },
+        "app.CartItem": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "img": {
+                    "description": "Img is stored as the id of an uploaded image and returned as a link to it; absolute URLs\npointing elsewhere are kept as they are.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "number"
+                },
+                "quantity": {
+                    "type": "integer"
+                }
+            }
+        },
+        "app.CartSummary": {
+            "type": "object",
+            "properties": {
+                "item_count": {
+                    "description": "ItemCount is the total number of units in the cart.",
+                    "type": "integer"
+                },
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/app.CartItem"
+                    }
+                },
+                "subtotal": {
+                    "type": "number"
+                }
+            }
+        },
+        "app.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.Image": {
+            "type": "object",
+            "properties": {
+                "content_type": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "ID is the name of the image in the BlobStore.",
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "uploaded_at": {
+                    "type": "string"
+                },
+                "uploaded_by": {
+                    "description": "UploadedBy is the id of the user who uploaded the image first; empty for images\nthat were put into the storage some other way.",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.Order": {
+            "type": "object",
+            "properties": {
+                "cards": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/app.OrderItem"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "paid",
+                        "shipped",
+                        "delivered",
+                        "cancelled"
+                    ]
+                },
+                "total": {
+                    "type": "number"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.OrderItem": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "img": {
+                    "description": "Img is stored as the id of an uploaded image and returned as a link to it; absolute URLs\npointing elsewhere are kept as they are.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "number"
+                },
+                "quantity": {
+                    "type": "integer"
+                }
+            }
+        },
+        "app.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/app.FieldError"
+                    }
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.SearchHit": {
+            "type": "object",
+            "properties": {
+                "fuzzy": {
+                    "description": "Fuzzy is set when the hit was found by the typo-tolerant fallback.",
+                    "type": "boolean"
+                },
+                "highlight": {
+                    "description": "Highlight is the HTML-escaped card name with matched words wrapped in \u003cmark\u003e.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "img": {
+                    "description": "Img is stored as the id of an uploaded image and returned as a link to it; absolute URLs\npointing elsewhere are kept as they are.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "price": {
+                    "type": "number"
+                },
+                "score": {
+                    "description": "Score is the relevance of the hit; higher is better.",
+                    "type": "number"
+                }
+            }
+        },
+        "app.Tokens": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "description": "ExpiresIn is the lifetime of the access token in seconds.",
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.User": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "role": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.authResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "description": "ExpiresIn is the lifetime of the access token in seconds.",
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "token_type": {
+                    "type": "string"
+                },
+                "user": {
+                    "$ref": "#/definitions/app.User"
+                }
+            }
+        },
+        "app.cartItemRequest": {
+            "type": "object",
+            "required": [
+                "id"
+            ],
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "quantity": {
+                    "description": "Quantity defaults to 1.",
+                    "type": "integer",
+                    "maximum": 999,
+                    "minimum": 0
+                }
+            }
+        },
+        "app.cartQuantityRequest": {
+            "type": "object",
+            "properties": {
+                "delta": {
+                    "description": "Delta is added to the quantity; negative values decrease it.",
+                    "type": "integer",
+                    "maximum": 999,
+                    "minimum": -999
+                },
+                "quantity": {
+                    "description": "Quantity replaces the quantity.",
+                    "type": "integer",
+                    "maximum": 999,
+                    "minimum": 0
+                }
+            }
+        },
+        "app.credentialsRequest": {
+            "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "maxLength": 254
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.favoriteRequest": {
+            "type": "object",
+            "required": [
+                "id"
+            ],
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "maxLength": 128
+                }
+            }
+        },
         "app.imageResponse": {
             "type": "object",
             "properties": {
+                "content_type": {
+                    "description": "ContentType is the type detected from the content of the file.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
                 "url": {
                     "type": "string"
                 }
             }
         },
+        "app.orderItemRequest": {
+            "type": "object",
+            "required": [
+                "id"
+            ],
+            "properties": {
+                "id": {
+                    "type": "string",
+                    "maxLength": 128
+                },
+                "quantity": {
+                    "description": "Quantity defaults to 1.",
+                    "type": "integer",
+                    "maximum": 999,
+                    "minimum": 0
+                }
+            }
+        },
         "app.orderRequest": {
             "type": "object",
+            "required": [
+                "cards"
+            ],
             "properties": {
                 "cards": {
                     "type": "array",
+                    "maxItems": 100,
+                    "minItems": 1,
                     "items": {
-                        "$ref": "#/definitions/app.Card"
+                        "$ref": "#/definitions/app.orderItemRequest"
                     }
                 }
             }
@@ -358,13 +2141,79 @@ const docTemplate = `{
                 "cards": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/app.Card"
+                        "$ref": "#/definitions/app.OrderItem"
                     }
                 },
                 "created_at": {
+                    "description": "CreatedAt labels the period: \"02.01.2006\" for a day or the Monday of a week, \"01.2006\" for a month.",
+                    "type": "string"
+                },
+                "from": {
+                    "description": "From and To bound the period [From, To) in the requested time zone.",
+                    "type": "string"
+                },
+                "orders": {
+                    "description": "Orders are the individual orders of the period whose cards are merged into Cards.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/app.Order"
+                    }
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "app.orderStatusRequest": {
+            "type": "object",
+            "required": [
+                "status"
+            ],
+            "properties": {
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "paid",
+                        "shipped",
+                        "delivered",
+                        "cancelled"
+                    ]
+                }
+            }
+        },
+        "app.refreshRequest": {
+            "type": "object",
+            "required": [
+                "refresh_token"
+            ],
+            "properties": {
+                "refresh_token": {
                     "type": "string"
                 }
             }
+        },
+        "app.roleRequest": {
+            "type": "object",
+            "required": [
+                "role"
+            ],
+            "properties": {
+                "role": {
+                    "type": "string",
+                    "enum": [
+                        "customer",
+                        "admin"
+                    ]
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "Access-токен в виде \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -379,6 +2228,8 @@ var SwaggerInfo = &swag.Spec{
 	Description:      "",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
+	LeftDelim:        "{{",
+	RightDelim:       "}}",
 }
 
 func init() {
@@ -8,48 +8,75 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	_ "github.com/IrinaChuprakova/mock-api/docs"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func ping(client *mongo.Client) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// @title           Swagger UI
+// @version         1.0
 
-	if err := client.Connect(ctx); err != nil {
-		return err
+// @securityDefinitions.apikey BearerAuth
+// @in                         header
+// @name                       Authorization
+// @description                Access-токен в виде "Bearer <token>"
+
+func Run(cfg Config) {
+	tokens, err := newTokenIssuer(cfg)
+	if err != nil {
+		log.Println(err)
+		return
 	}
 
-	if err := client.Ping(context.Background(), nil); err != nil {
-		return err
+	store, err := openStore(cfg)
+	if err != nil {
+		log.Println(err)
+		return
 	}
+	defer func() {
+		if err := store.Close(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
 
-	return nil
-}
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer func() {
+		if err := blobs.Close(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
 
-// @title           Swagger UI
-// @version         1.0
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-func Run() {
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://mongo:27017"))
+	cors, err := newCORSPolicy(cfg)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	if len(cors.origins) > 0 && !cors.credentials {
+		log.Println("cors: cors_allow_credentials is off, so browsers on other origins do not send the session_id cookie and anonymous carts and favorites are lost between requests")
+	}
 
-	if err = ping(client); err != nil {
+	variants, err := newVariantCache(cfg.ImageCacheDir, cfg.ImageCacheSize)
+	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	db := client.Database("cards")
+	sweeping, stopSweeping := context.WithCancel(context.Background())
+	defer stopSweeping()
+	startSweeper(sweeping, store, blobs, variants, cfg)
 
 	server := &http.Server{
-		Addr:    ":8080",
-		Handler: newRouter(db),
+		Addr:    cfg.Addr,
+		Handler: newRouter(store, blobs, variants, tokens, proxies, cors, cfg),
 	}
 
 	go func() {
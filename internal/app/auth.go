@@ -0,0 +1,472 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	jwtHS256 = "HS256"
+	jwtRS256 = "RS256"
+
+	tokenIssuerName  = "mock-api"
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+
+	minPasswordLength = 8
+	// bcrypt ignores everything after the 72nd byte.
+	maxPasswordLength = 72
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+type User struct {
+	ID           string    `json:"id" bson:"_id"`
+	Email        string    `json:"email" bson:"email"`
+	PasswordHash string    `json:"-" bson:"password_hash"`
+	Role         string    `json:"role" bson:"role"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID string
+	Email  string
+	Role   string
+}
+
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// ExpiresIn is the lifetime of the access token in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	Type  string `json:"typ"`
+}
+
+// tokenIssuer signs and verifies access and refresh tokens.
+type tokenIssuer struct {
+	method     jwt.SigningMethod
+	signKey    interface{}
+	verifyKey  interface{}
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func newTokenIssuer(cfg Config) (*tokenIssuer, error) {
+	issuer := &tokenIssuer{
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+	}
+
+	switch cfg.JWTAlgorithm {
+	case jwtHS256:
+		secret := []byte(cfg.JWTSecret)
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+			log.Println("jwt secret is not configured, tokens will not survive a restart")
+		}
+
+		issuer.method, issuer.signKey, issuer.verifyKey = jwt.SigningMethodHS256, secret, secret
+	case jwtRS256:
+		pem, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.JWTPrivateKeyFile, err)
+		}
+
+		issuer.method, issuer.signKey, issuer.verifyKey = jwt.SigningMethodRS256, key, &key.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", cfg.JWTAlgorithm)
+	}
+
+	return issuer, nil
+}
+
+func (t *tokenIssuer) sign(user User, tokenType string, ttl time.Duration, now time.Time) (string, error) {
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuerName,
+			Subject:   user.ID,
+			ID:        uuid.New().String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Email: user.Email,
+		Role:  effectiveRole(user.Role),
+		Type:  tokenType,
+	}
+
+	return jwt.NewWithClaims(t.method, claims).SignedString(t.signKey)
+}
+
+func (t *tokenIssuer) issue(user User) (Tokens, error) {
+	now := time.Now()
+
+	access, err := t.sign(user, tokenTypeAccess, t.accessTTL, now)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	refresh, err := t.sign(user, tokenTypeRefresh, t.refreshTTL, now)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(t.accessTTL / time.Second),
+	}, nil
+}
+
+// parse verifies the signature, issuer, expiry and type of a token.
+func (t *tokenIssuer) parse(raw, tokenType string) (*tokenClaims, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return t.verifyKey, nil
+	}, jwt.WithValidMethods([]string{t.method.Alg()}), jwt.WithIssuer(tokenIssuerName))
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	if claims.ExpiresAt == nil || claims.Subject == "" || claims.Type != tokenType {
+		return nil, errInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// Authenticate attaches the Principal of a valid bearer access token to the request context.
+// Requests without a token pass through anonymously; requests with an invalid one get 401.
+func Authenticate(tokens *tokenIssuer) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			raw := bearerToken(request)
+			if raw == "" {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+
+			claims, err := tokens.parse(raw, tokenTypeAccess)
+			if err != nil {
+				writer.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeProblem(writer, http.StatusUnauthorized, codeInvalidToken, "the access token is invalid or expired")
+				return
+			}
+
+			principal := Principal{UserID: claims.Subject, Email: claims.Email, Role: claims.Role}
+			ctx := context.WithValue(request.Context(), principalKey{}, principal)
+			handler.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// RequireAuth rejects anonymous requests with 401.
+func RequireAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if _, ok := principalFromContext(request.Context()); !ok {
+			writer.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(writer, http.StatusUnauthorized, codeUnauthorized, "an access token is required")
+			return
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+type credentialsRequest struct {
+	Email    string `json:"email" validate:"required,max=254"`
+	Password string `json:"password" validate:"required"`
+}
+
+type authResponse struct {
+	Tokens
+	User User `json:"user"`
+}
+
+func normalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", false
+	}
+
+	return email, true
+}
+
+// Register godoc
+// @Summary      Зарегистрировать пользователя
+// @Description  Новые пользователи получают роль customer, адреса из настройки admin_emails — роль admin.
+// @Tags         auth
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        request body credentialsRequest true "body"
+// @Success      201 {object} authResponse
+// @Failure      400 {object} Problem
+// @Failure      409 {object} Problem
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/auth/register [post]
+func Register(users UserRepository, tokens *tokenIssuer, adminEmails []string) http.HandlerFunc {
+	admins := make(map[string]bool, len(adminEmails))
+	for _, email := range adminEmails {
+		email, _ = normalizeEmail(email)
+		admins[email] = true
+	}
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body credentialsRequest
+		if !handleRequest(writer, request, &body) {
+			return
+		}
+
+		var fields []FieldError
+		email, ok := normalizeEmail(body.Email)
+		if !ok {
+			fields = append(fields, invalidField("email", "email is not valid"))
+		}
+		if len(body.Password) < minPasswordLength || len(body.Password) > maxPasswordLength {
+			fields = append(fields, invalidField("password",
+				fmt.Sprintf("password must be %d to %d bytes long", minPasswordLength, maxPasswordLength)))
+		}
+		if len(fields) > 0 {
+			writeValidationError(writer, fields...)
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		user := User{
+			ID:           uuid.New().String(),
+			Email:        email,
+			PasswordHash: string(hash),
+			Role:         roleCustomer,
+			CreatedAt:    time.Now().UTC(),
+		}
+		if admins[email] {
+			user.Role = roleAdmin
+		}
+
+		if err = users.Create(request.Context(), user); err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		issued, err := tokens.issue(user)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusCreated, authResponse{Tokens: issued, User: user})
+	}
+}
+
+// dummyPasswordHash is compared against when the user does not exist, so that a login
+// attempt takes about as long for unknown emails as for wrong passwords.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("mock-api-dummy-password"), bcrypt.DefaultCost)
+
+// Login godoc
+// @Summary      Войти по email и паролю
+// @Tags         auth
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        request body credentialsRequest true "body"
+// @Success      200 {object} authResponse
+// @Failure      401 {object} Problem
+// @Failure      400 {object} Problem "некорректный JSON"
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/auth/login [post]
+func Login(users UserRepository, tokens *tokenIssuer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body credentialsRequest
+		if !handleRequest(writer, request, &body) {
+			return
+		}
+
+		email, _ := normalizeEmail(body.Email)
+		user, err := users.GetByEmail(request.Context(), email)
+		if err != nil && !errors.Is(err, errNotFound) {
+			writeInternalError(writer, err)
+			return
+		}
+
+		hash := []byte(user.PasswordHash)
+		if err != nil {
+			hash = dummyPasswordHash
+		}
+
+		if bcrypt.CompareHashAndPassword(hash, []byte(body.Password)) != nil || err != nil {
+			writeProblem(writer, http.StatusUnauthorized, codeInvalidCredentials, "wrong email or password")
+			return
+		}
+
+		issued, err := tokens.issue(user)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, authResponse{Tokens: issued, User: user})
+	}
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh godoc
+// @Summary      Обновить пару токенов по refresh-токену
+// @Tags         auth
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        request body refreshRequest true "body"
+// @Success      200 {object} Tokens
+// @Failure      401 {object} Problem
+// @Failure      400 {object} Problem "некорректный JSON"
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/auth/refresh [post]
+func Refresh(users UserRepository, tokens *tokenIssuer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body refreshRequest
+		if !handleRequest(writer, request, &body) {
+			return
+		}
+
+		claims, err := tokens.parse(body.RefreshToken, tokenTypeRefresh)
+		if err != nil {
+			writeProblem(writer, http.StatusUnauthorized, codeInvalidToken, "the refresh token is invalid or expired")
+			return
+		}
+
+		user, err := users.Get(request.Context(), claims.Subject)
+		if errors.Is(err, errNotFound) {
+			writeProblem(writer, http.StatusUnauthorized, codeInvalidToken, "the user of the token no longer exists")
+			return
+		}
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		issued, err := tokens.issue(user)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, issued)
+	}
+}
+
+// Me godoc
+// @Summary      Текущий пользователь
+// @Tags         auth
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @Security     BearerAuth
+// @Success      200 {object} User
+// @Failure      401 {object} Problem
+// @Router       /api/auth/me [get]
+func Me(users UserRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		principal, _ := principalFromContext(request.Context())
+
+		user, err := users.Get(request.Context(), principal.UserID)
+		if errors.Is(err, errNotFound) {
+			writeProblem(writer, http.StatusUnauthorized, codeInvalidToken, "the user of the token no longer exists")
+			return
+		}
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, user)
+	}
+}
+
+type roleRequest struct {
+	Role string `json:"role" enums:"customer,admin" validate:"required,oneof=customer admin"`
+}
+
+// SetUserRole godoc
+// @Summary      Назначить роль пользователю
+// @Description  Новая роль попадает в токены пользователя при следующем входе или обновлении токенов.
+// @Tags         auth
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @Security     BearerAuth
+// @param        id path string true "id пользователя"
+// @param        request body roleRequest true "body"
+// @Success      200 {object} User
+// @Failure      400 {object} Problem
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      404 {object} Problem
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/users/{id}/role [put]
+func SetUserRole(users UserRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body roleRequest
+		if !handleRequest(writer, request, &body) {
+			return
+		}
+
+		id := chi.URLParam(request, "id")
+		if err := users.SetRole(request.Context(), id, body.Role); err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		user, err := users.Get(request.Context(), id)
+		if err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, user)
+	}
+}
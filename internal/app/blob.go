@@ -0,0 +1,195 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	blobStoreLocal  = "local"
+	blobStoreGridFS = "gridfs"
+	blobStoreS3     = "s3"
+)
+
+// BlobInfo describes a stored image.
+type BlobInfo struct {
+	Name        string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Blob is an open stored image. It is seekable so that it can be served with http.ServeContent.
+type Blob struct {
+	BlobInfo
+	io.ReadSeekCloser
+}
+
+// BlobStore keeps the uploaded images.
+type BlobStore interface {
+	// Put stores size bytes of content under name. Names are derived from the content, so if a
+	// blob with the name already exists it is kept, with its modification time refreshed.
+	Put(ctx context.Context, name, contentType string, content io.Reader, size int64) error
+	// Open returns the blob with the name or errNotFound.
+	Open(ctx context.Context, name string) (Blob, error)
+	// Delete removes the blob with the name or returns errNotFound.
+	Delete(ctx context.Context, name string) error
+	// List describes every stored blob. ContentType is left empty where the backend would
+	// have to fetch each blob to learn it.
+	List(ctx context.Context) ([]BlobInfo, error)
+	Close(ctx context.Context) error
+}
+
+func openBlobStore(cfg Config) (BlobStore, error) {
+	switch cfg.BlobStore {
+	case blobStoreLocal:
+		return newLocalBlobs(cfg.StorageDir)
+	case blobStoreGridFS:
+		return newGridFSBlobs(cfg.MongoURI, cfg.MongoDatabase)
+	case blobStoreS3:
+		return newS3Blobs(cfg)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
+
+// localBlobs keeps images as files in a directory.
+type localBlobs struct {
+	dir string
+}
+
+func newLocalBlobs(dir string) (localBlobs, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return localBlobs{}, err
+	}
+
+	return localBlobs{dir}, nil
+}
+
+// Put writes content to a temporary file next to its destination and renames it into place once
+// it is complete, so readers never see a partial file. Uploading an image that is already there
+// only refreshes its modification time, so that the collector gives it a new grace period.
+func (s localBlobs) Put(_ context.Context, name, _ string, content io.Reader, _ int64) (err error) {
+	path := filepath.Join(s.dir, name)
+	if _, err = os.Stat(path); err == nil {
+		now := time.Now()
+		return os.Chtimes(path, now, now)
+	}
+
+	temp, err := os.CreateTemp(s.dir, tempFilePattern)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if removeErr := os.Remove(temp.Name()); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+				log.Println(removeErr)
+			}
+		}
+	}()
+
+	_, err = io.Copy(temp, content)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = os.Chmod(temp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+func (s localBlobs) Open(_ context.Context, name string) (Blob, error) {
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Blob{}, errNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+
+	blob, err := s.describe(name, file)
+	if err != nil {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+		return Blob{}, err
+	}
+
+	return blob, nil
+}
+
+func (s localBlobs) describe(name string, file *os.File) (Blob, error) {
+	stats, err := file.Stat()
+	if err != nil {
+		return Blob{}, err
+	}
+
+	if !stats.Mode().IsRegular() {
+		return Blob{}, errNotFound
+	}
+
+	contentType, err := storedImageType(name, file)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	return Blob{
+		BlobInfo:       BlobInfo{Name: name, Size: stats.Size(), ContentType: contentType, ModTime: stats.ModTime()},
+		ReadSeekCloser: file,
+	}, nil
+}
+
+func (s localBlobs) Delete(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return errNotFound
+	}
+
+	return err
+}
+
+func (s localBlobs) List(context.Context) ([]BlobInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]BlobInfo, 0, len(entries))
+	for _, entry := range entries {
+		// Dot files are uploads that are still being written.
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		stats, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		blobs = append(blobs, BlobInfo{Name: entry.Name(), Size: stats.Size(), ModTime: stats.ModTime()})
+	}
+
+	return blobs, nil
+}
+
+func (s localBlobs) Close(context.Context) error {
+	return nil
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const imagesBucketName = "images"
+
+// gridFSBlobs keeps images in a GridFS bucket, with the content type in the file metadata.
+//
+// Only the stream-based methods of gridfs.Bucket are used: the others share buffers
+// between calls and are not safe for concurrent requests.
+type gridFSBlobs struct {
+	bucket *gridfs.Bucket
+	client *mongo.Client
+}
+
+type gridFSFile struct {
+	ID         interface{} `bson:"_id"`
+	Filename   string      `bson:"filename"`
+	Length     int64       `bson:"length"`
+	UploadDate time.Time   `bson:"uploadDate"`
+	Metadata   struct {
+		ContentType string `bson:"content_type"`
+	} `bson:"metadata"`
+}
+
+func newGridFSBlobs(uri, database string) (gridFSBlobs, error) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		return gridFSBlobs{}, err
+	}
+
+	if err = ping(client); err != nil {
+		return gridFSBlobs{}, err
+	}
+
+	bucket, err := gridfs.NewBucket(client.Database(database), options.GridFSBucket().SetName(imagesBucketName))
+	if err != nil {
+		return gridFSBlobs{}, err
+	}
+
+	return gridFSBlobs{bucket: bucket, client: client}, nil
+}
+
+func (s gridFSBlobs) find(ctx context.Context, name string) (gridFSFile, error) {
+	var file gridFSFile
+	err := s.bucket.GetFilesCollection().FindOne(ctx, bson.D{{Key: "filename", Value: name}}).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return gridFSFile{}, errNotFound
+	}
+
+	return file, err
+}
+
+// Put stores content unless the image is already there, in which case its upload date is
+// refreshed, so that the collector gives it a new grace period.
+func (s gridFSBlobs) Put(ctx context.Context, name, contentType string, content io.Reader, _ int64) error {
+	file, err := s.find(ctx, name)
+	if err == nil {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "uploadDate", Value: time.Now()}}}}
+		_, err = s.bucket.GetFilesCollection().UpdateByID(ctx, file.ID, update)
+		return err
+	}
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	stream, err := s.bucket.OpenUploadStream(name, options.GridFSUpload().SetMetadata(bson.D{{Key: "content_type", Value: contentType}}))
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = stream.SetWriteDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	if _, err = io.Copy(stream, content); err != nil {
+		if abortErr := stream.Abort(); abortErr != nil {
+			return abortErr
+		}
+		return err
+	}
+
+	return stream.Close()
+}
+
+func (s gridFSBlobs) Open(ctx context.Context, name string) (Blob, error) {
+	file, err := s.find(ctx, name)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	return Blob{
+		BlobInfo: BlobInfo{
+			Name:        name,
+			Size:        file.Length,
+			ContentType: file.Metadata.ContentType,
+			ModTime:     file.UploadDate,
+		},
+		ReadSeekCloser: &gridFSReader{bucket: s.bucket, id: file.ID, size: file.Length},
+	}, nil
+}
+
+func (s gridFSBlobs) Delete(ctx context.Context, name string) error {
+	file, err := s.find(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	err = s.bucket.DeleteContext(ctx, file.ID)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return errNotFound
+	}
+
+	return err
+}
+
+func (s gridFSBlobs) List(ctx context.Context) ([]BlobInfo, error) {
+	cursor, err := s.bucket.GetFilesCollection().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	var files []gridFSFile
+	if err = cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	blobs := make([]BlobInfo, 0, len(files))
+	for _, file := range files {
+		blobs = append(blobs, BlobInfo{
+			Name:        file.Filename,
+			Size:        file.Length,
+			ContentType: file.Metadata.ContentType,
+			ModTime:     file.UploadDate,
+		})
+	}
+
+	return blobs, nil
+}
+
+func (s gridFSBlobs) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// gridFSReader makes a GridFS file seekable. GridFS download streams only read forward, so
+// seeking back reopens the stream and seeking forward skips ahead.
+type gridFSReader struct {
+	bucket *gridfs.Bucket
+	id     interface{}
+	size   int64
+
+	// offset is where the next Read starts; streamOffset is where stream currently is.
+	offset       int64
+	stream       *gridfs.DownloadStream
+	streamOffset int64
+}
+
+func (r *gridFSReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.stream == nil || r.streamOffset > r.offset {
+		if err := r.Close(); err != nil {
+			return 0, err
+		}
+
+		stream, err := r.bucket.OpenDownloadStream(r.id)
+		if err != nil {
+			return 0, err
+		}
+		r.stream, r.streamOffset = stream, 0
+	}
+
+	if r.streamOffset < r.offset {
+		skipped, err := r.stream.Skip(r.offset - r.streamOffset)
+		r.streamOffset += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.stream.Read(p)
+	r.offset += int64(n)
+	r.streamOffset += int64(n)
+	return n, err
+}
+
+func (r *gridFSReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("gridfs: invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("gridfs: negative position")
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *gridFSReader) Close() error {
+	if r.stream == nil {
+		return nil
+	}
+
+	err := r.stream.Close()
+	r.stream = nil
+	return err
+}
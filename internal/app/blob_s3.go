@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Blobs keeps images in a bucket of an S3-compatible service such as MinIO.
+type s3Blobs struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Blobs(cfg Config) (s3Blobs, error) {
+	endpoint, err := url.Parse(cfg.S3Endpoint)
+	if err != nil {
+		return s3Blobs{}, err
+	}
+
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure: endpoint.Scheme == "https",
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return s3Blobs{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, cfg.S3Bucket)
+	if err != nil {
+		return s3Blobs{}, fmt.Errorf("s3: %w", err)
+	}
+
+	if !exists {
+		if err = client.MakeBucket(ctx, cfg.S3Bucket, minio.MakeBucketOptions{Region: cfg.S3Region}); err != nil {
+			return s3Blobs{}, fmt.Errorf("s3: %w", err)
+		}
+	}
+
+	return s3Blobs{client: client, bucket: cfg.S3Bucket}, nil
+}
+
+// Put stores content unless the image is already there, in which case the object is copied onto
+// itself to refresh its modification time, so that the collector gives it a new grace period.
+func (s s3Blobs) Put(ctx context.Context, name, contentType string, content io.Reader, size int64) error {
+	info, err := s.stat(ctx, name)
+	if err == nil {
+		_, err = s.client.CopyObject(ctx,
+			minio.CopyDestOptions{
+				Bucket:          s.bucket,
+				Object:          name,
+				ReplaceMetadata: true,
+				UserMetadata:    map[string]string{"Content-Type": info.ContentType},
+			},
+			minio.CopySrcOptions{Bucket: s.bucket, Object: name})
+		return err
+	}
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	_, err = s.client.PutObject(ctx, s.bucket, name, content, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s s3Blobs) stat(ctx context.Context, name string) (minio.ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, name, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return minio.ObjectInfo{}, errNotFound
+	}
+
+	return info, err
+}
+
+func (s s3Blobs) Open(ctx context.Context, name string) (Blob, error) {
+	info, err := s.stat(ctx, name)
+	if err != nil {
+		return Blob{}, err
+	}
+
+	// The object is fetched lazily and supports Seek, issuing ranged requests as needed.
+	object, err := s.client.GetObject(ctx, s.bucket, name, minio.GetObjectOptions{})
+	if err != nil {
+		return Blob{}, err
+	}
+
+	return Blob{
+		BlobInfo: BlobInfo{
+			Name:        name,
+			Size:        info.Size,
+			ContentType: info.ContentType,
+			ModTime:     info.LastModified,
+		},
+		ReadSeekCloser: object,
+	}, nil
+}
+
+func (s s3Blobs) Delete(ctx context.Context, name string) error {
+	// RemoveObject succeeds for missing objects too.
+	if _, err := s.stat(ctx, name); err != nil {
+		return err
+	}
+
+	return s.client.RemoveObject(ctx, s.bucket, name, minio.RemoveObjectOptions{})
+}
+
+func (s s3Blobs) List(ctx context.Context) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		blobs = append(blobs, BlobInfo{Name: object.Key, Size: object.Size, ModTime: object.LastModified})
+	}
+
+	return blobs, nil
+}
+
+func (s s3Blobs) Close(context.Context) error {
+	return nil
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// newTestS3Blobs connects to the MinIO of the compose "s3" profile, or any S3-compatible service,
+// named by MOCK_API_TEST_S3_ENDPOINT, e.g. http://localhost:9000. Every test gets a bucket of its
+// own, removed afterwards.
+func newTestS3Blobs(t *testing.T) s3Blobs {
+	t.Helper()
+
+	endpoint := os.Getenv("MOCK_API_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MOCK_API_TEST_S3_ENDPOINT is not set; start MinIO with `docker compose --profile s3 up minio`")
+	}
+
+	cfg := defaultConfig()
+	cfg.S3Endpoint = endpoint
+	cfg.S3AccessKey = envOr("MOCK_API_TEST_S3_ACCESS_KEY", "minioadmin")
+	cfg.S3SecretKey = envOr("MOCK_API_TEST_S3_SECRET_KEY", "minioadmin")
+	cfg.S3Bucket = fmt.Sprintf("mock-api-test-%d", time.Now().UnixNano())
+
+	blobs, err := newS3Blobs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		for object := range blobs.client.ListObjects(ctx, blobs.bucket, minio.ListObjectsOptions{}) {
+			if object.Err == nil {
+				if err := blobs.client.RemoveObject(ctx, blobs.bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+					t.Log(err)
+				}
+			}
+		}
+		if err := blobs.client.RemoveBucket(ctx, blobs.bucket); err != nil {
+			t.Log(err)
+		}
+	})
+
+	return blobs
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func putString(t *testing.T, blobs BlobStore, name, contentType, content string) {
+	t.Helper()
+
+	if err := blobs.Put(context.Background(), name, contentType, strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Put(%s): %v", name, err)
+	}
+}
+
+func readBlob(t *testing.T, blobs BlobStore, name string) (BlobInfo, string) {
+	t.Helper()
+
+	blob, err := blobs.Open(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", name, err)
+	}
+	defer blob.Close()
+
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob.BlobInfo, string(content)
+}
+
+func TestS3BlobsPutOpen(t *testing.T) {
+	blobs := newTestS3Blobs(t)
+
+	putString(t, blobs, "a.png", "image/png", "first")
+
+	info, content := readBlob(t, blobs, "a.png")
+	if content != "first" {
+		t.Errorf("content = %q, want %q", content, "first")
+	}
+	if info.Name != "a.png" || info.Size != 5 || info.ContentType != "image/png" || info.ModTime.IsZero() {
+		t.Errorf("info = %+v, want a.png, 5 bytes, image/png and a modification time", info)
+	}
+
+	blob, err := blobs.Open(context.Background(), "a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+
+	// Range requests of http.ServeContent seek within the object.
+	if _, err = blob.Seek(2, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, []byte("rst")) {
+		t.Errorf("after Seek(2) read %q, want %q", rest, "rst")
+	}
+}
+
+func TestS3BlobsPutExisting(t *testing.T) {
+	blobs := newTestS3Blobs(t)
+
+	putString(t, blobs, "a.png", "image/png", "first")
+	before, _ := readBlob(t, blobs, "a.png")
+
+	// S3 keeps modification times in whole seconds.
+	time.Sleep(1100 * time.Millisecond)
+	putString(t, blobs, "a.png", "image/jpeg", "second")
+
+	after, content := readBlob(t, blobs, "a.png")
+	if content != "first" {
+		t.Errorf("content = %q, want the first upload to be kept", content)
+	}
+	if after.ContentType != "image/png" {
+		t.Errorf("content type = %q, want image/png to be kept", after.ContentType)
+	}
+	if !after.ModTime.After(before.ModTime) {
+		t.Errorf("modification time %v not refreshed after %v", after.ModTime, before.ModTime)
+	}
+}
+
+func TestS3BlobsList(t *testing.T) {
+	blobs := newTestS3Blobs(t)
+
+	if list, err := blobs.List(context.Background()); err != nil || len(list) != 0 {
+		t.Fatalf("List() of an empty bucket = %v, %v", list, err)
+	}
+
+	putString(t, blobs, "a.png", "image/png", "aa")
+	putString(t, blobs, "b.gif", "image/gif", "bbb")
+
+	list, err := blobs.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := make(map[string]int64)
+	for _, info := range list {
+		sizes[info.Name] = info.Size
+		if info.ModTime.IsZero() {
+			t.Errorf("%s has no modification time", info.Name)
+		}
+	}
+	if len(sizes) != 2 || sizes["a.png"] != 2 || sizes["b.gif"] != 3 {
+		t.Errorf("List() sizes = %v, want a.png: 2, b.gif: 3", sizes)
+	}
+}
+
+func TestS3BlobsDelete(t *testing.T) {
+	blobs := newTestS3Blobs(t)
+	ctx := context.Background()
+
+	putString(t, blobs, "a.png", "image/png", "first")
+	if err := blobs.Delete(ctx, "a.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := blobs.Open(ctx, "a.png"); !errors.Is(err, errNotFound) {
+		t.Errorf("Open() after Delete() = %v, want errNotFound", err)
+	}
+	if err := blobs.Delete(ctx, "a.png"); !errors.Is(err, errNotFound) {
+		t.Errorf("second Delete() = %v, want errNotFound", err)
+	}
+}
+
+func TestS3BlobsNotFound(t *testing.T) {
+	blobs := newTestS3Blobs(t)
+	ctx := context.Background()
+
+	if _, err := blobs.Open(ctx, "missing.png"); !errors.Is(err, errNotFound) {
+		t.Errorf("Open() = %v, want errNotFound", err)
+	}
+	if err := blobs.Delete(ctx, "missing.png"); !errors.Is(err, errNotFound) {
+		t.Errorf("Delete() = %v, want errNotFound", err)
+	}
+}
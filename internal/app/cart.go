@@ -0,0 +1,42 @@
+package app
+
+import (
+	"math"
+)
+
+// maxCartQuantity is the largest quantity of a single card a cart line may hold. The validate
+// tags of the request quantities repeat it.
+const maxCartQuantity = 999
+
+type CartItem struct {
+	Card
+	Quantity int `json:"quantity"`
+}
+
+// CartSummary is the cart as returned to clients.
+type CartSummary struct {
+	Items []CartItem `json:"items"`
+	// ItemCount is the total number of units in the cart.
+	ItemCount int     `json:"item_count"`
+	Subtotal  float64 `json:"subtotal"`
+}
+
+func newCartSummary(items []CartItem) CartSummary {
+	summary := CartSummary{Items: items}
+	if summary.Items == nil {
+		summary.Items = []CartItem{}
+	}
+
+	for _, item := range items {
+		summary.ItemCount += item.Quantity
+		summary.Subtotal += item.Price * float64(item.Quantity)
+	}
+	summary.Subtotal = roundPrice(summary.Subtotal)
+
+	return summary
+}
+
+// roundPrice rounds to whole cents to hide floating-point noise in sums.
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
+}
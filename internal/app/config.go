@@ -0,0 +1,486 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "MOCK_API_"
+
+// Config describes everything Run needs to start the API.
+//
+// Values are resolved in the following order, each step overriding the previous one:
+// defaults, YAML file (-config / MOCK_API_CONFIG), environment variables, command-line flags.
+type Config struct {
+	Addr          string `yaml:"addr"`
+	Store         string `yaml:"store"`
+	StoreFile     string `yaml:"store_file"`
+	MongoURI      string `yaml:"mongo_uri"`
+	MongoDatabase string `yaml:"mongo_database"`
+	// BaseURL is the public URL of the API used in links to images. When empty it is worked out
+	// from each request, trusting X-Forwarded-Proto and X-Forwarded-Host from TrustedProxies only.
+	BaseURL        string   `yaml:"base_url"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// CORSAllowedOrigins are the browser origins allowed to call the API, "*" for any; an empty
+	// list turns CORS off. Credentials let browsers send the session cookie, so that anonymous
+	// carts and favorites survive cross-origin requests; they need explicit origins.
+	CORSAllowedOrigins   []string      `yaml:"cors_allowed_origins"`
+	CORSAllowedMethods   []string      `yaml:"cors_allowed_methods"`
+	CORSAllowedHeaders   []string      `yaml:"cors_allowed_headers"`
+	CORSExposedHeaders   []string      `yaml:"cors_exposed_headers"`
+	CORSAllowCredentials bool          `yaml:"cors_allow_credentials"`
+	CORSMaxAge           time.Duration `yaml:"cors_max_age"`
+
+	// BlobStore is where uploaded images are kept: local (StorageDir), gridfs (the Mongo
+	// database) or s3.
+	BlobStore  string `yaml:"blob_store"`
+	StorageDir string `yaml:"storage_dir"`
+	// ImageCacheDir keeps resized variants of the images, up to ImageCacheSize bytes; the least
+	// recently used variants are evicted beyond that.
+	ImageCacheDir  string   `yaml:"image_cache_dir"`
+	ImageCacheSize ByteSize `yaml:"image_cache_size"`
+	S3Endpoint     string   `yaml:"s3_endpoint"`
+	S3Region       string   `yaml:"s3_region"`
+	S3Bucket       string   `yaml:"s3_bucket"`
+	S3AccessKey    string   `yaml:"s3_access_key"`
+	S3SecretKey    string   `yaml:"s3_secret_key"`
+	// MaxUploadSize limits the size of an uploaded image.
+	MaxUploadSize ByteSize `yaml:"max_upload_size"`
+	// GCInterval is how often images that no card shows are collected; zero disables the
+	// background sweeper. Images younger than GCGracePeriod are kept, and with GCDryRun they are
+	// only reported.
+	GCInterval    time.Duration `yaml:"gc_interval"`
+	GCGracePeriod time.Duration `yaml:"gc_grace_period"`
+	GCDryRun      bool          `yaml:"gc_dry_run"`
+
+	JWTAlgorithm      string        `yaml:"jwt_algorithm"`
+	JWTSecret         string        `yaml:"jwt_secret"`
+	JWTPrivateKeyFile string        `yaml:"jwt_private_key_file"`
+	AccessTokenTTL    time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL   time.Duration `yaml:"refresh_token_ttl"`
+	// AdminEmails get the admin role when they register.
+	AdminEmails []string `yaml:"admin_emails"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Addr:                 ":8080",
+		Store:                storeMongo,
+		MongoURI:             "mongodb://mongo:27017",
+		MongoDatabase:        "cards",
+		CORSAllowedOrigins:   []string{"http://localhost:*", "http://127.0.0.1:*"},
+		CORSAllowCredentials: true,
+		CORSAllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		CORSAllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", requestIDHeader, userIDHeader, "X-Requested-With"},
+		CORSExposedHeaders:   []string{"X-Total-Count", "X-Next-Cursor", "Link", requestIDHeader},
+		CORSMaxAge:           10 * time.Minute,
+		BlobStore:            blobStoreLocal,
+		StorageDir:           "./storage",
+		S3Bucket:             "images",
+		ImageCacheDir:        "./cache/images",
+		ImageCacheSize:       512 << 20,
+		MaxUploadSize:        10 << 20,
+		GCInterval:           time.Hour,
+		GCGracePeriod:        24 * time.Hour,
+
+		JWTAlgorithm:    jwtHS256,
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// configField binds a Config field to its flag and environment variable.
+type configField struct {
+	flag  string
+	env   string
+	usage string
+	value func(cfg *Config) flag.Value
+}
+
+var configFields = []configField{
+	{"addr", "ADDR", "listen address", stringField(func(cfg *Config) *string { return &cfg.Addr })},
+	{"store", "STORE", "persistence backend: mongo or memory", stringField(func(cfg *Config) *string { return &cfg.Store })},
+	{"store-file", "STORE_FILE", "JSON file the memory store is loaded from and saved to (optional)", stringField(func(cfg *Config) *string { return &cfg.StoreFile })},
+	{"mongo-uri", "MONGO_URI", "mongo connection string", stringField(func(cfg *Config) *string { return &cfg.MongoURI })},
+	{"mongo-db", "MONGO_DATABASE", "mongo database name", stringField(func(cfg *Config) *string { return &cfg.MongoDatabase })},
+	{"blob-store", "BLOB_STORE", "image storage backend: local, gridfs or s3", stringField(func(cfg *Config) *string { return &cfg.BlobStore })},
+	{"storage-dir", "STORAGE_DIR", "directory for uploaded images of the local blob store", stringField(func(cfg *Config) *string { return &cfg.StorageDir })},
+	{"image-cache-dir", "IMAGE_CACHE_DIR", "directory for resized image variants", stringField(func(cfg *Config) *string { return &cfg.ImageCacheDir })},
+	{"image-cache-size", "IMAGE_CACHE_SIZE", "largest total size of the resized image variants, in bytes or with a KB, MB or GB suffix", byteSizeField(func(cfg *Config) *ByteSize { return &cfg.ImageCacheSize })},
+	{"s3-endpoint", "S3_ENDPOINT", "URL of the S3-compatible service, e.g. http://minio:9000", stringField(func(cfg *Config) *string { return &cfg.S3Endpoint })},
+	{"s3-region", "S3_REGION", "S3 region (optional)", stringField(func(cfg *Config) *string { return &cfg.S3Region })},
+	{"s3-bucket", "S3_BUCKET", "S3 bucket for images, created if missing", stringField(func(cfg *Config) *string { return &cfg.S3Bucket })},
+	{"s3-access-key", "S3_ACCESS_KEY", "S3 access key", stringField(func(cfg *Config) *string { return &cfg.S3AccessKey })},
+	{"s3-secret-key", "S3_SECRET_KEY", "S3 secret key", stringField(func(cfg *Config) *string { return &cfg.S3SecretKey })},
+	{"base-url", "BASE_URL", "public URL used to build links to uploaded images; taken from each request when empty", stringField(func(cfg *Config) *string { return &cfg.BaseURL })},
+	{"trusted-proxies", "TRUSTED_PROXIES", "comma-separated IPs and CIDR ranges whose X-Forwarded-Proto/Host headers are trusted", stringListField(func(cfg *Config) *[]string { return &cfg.TrustedProxies })},
+	{"cors-allowed-origins", "CORS_ALLOWED_ORIGINS", "comma-separated origins allowed to call the API from a browser, * for any, e.g. https://*.example.com; empty disables CORS", stringListField(func(cfg *Config) *[]string { return &cfg.CORSAllowedOrigins })},
+	{"cors-allowed-methods", "CORS_ALLOWED_METHODS", "comma-separated methods allowed in cross-origin requests", stringListField(func(cfg *Config) *[]string { return &cfg.CORSAllowedMethods })},
+	{"cors-allowed-headers", "CORS_ALLOWED_HEADERS", "comma-separated request headers allowed in cross-origin requests, * for any", stringListField(func(cfg *Config) *[]string { return &cfg.CORSAllowedHeaders })},
+	{"cors-exposed-headers", "CORS_EXPOSED_HEADERS", "comma-separated response headers readable by cross-origin pages", stringListField(func(cfg *Config) *[]string { return &cfg.CORSExposedHeaders })},
+	{"cors-allow-credentials", "CORS_ALLOW_CREDENTIALS", "let cross-origin requests send cookies; needs explicit origins", boolField(func(cfg *Config) *bool { return &cfg.CORSAllowCredentials })},
+	{"cors-max-age", "CORS_MAX_AGE", "how long browsers may cache a preflight answer", durationField(func(cfg *Config) *time.Duration { return &cfg.CORSMaxAge })},
+	{"max-upload-size", "MAX_UPLOAD_SIZE", "largest accepted upload, in bytes or with a KB, MB or GB suffix", byteSizeField(func(cfg *Config) *ByteSize { return &cfg.MaxUploadSize })},
+	{"gc-interval", "GC_INTERVAL", "how often unused images are removed; 0 disables the sweeper", durationField(func(cfg *Config) *time.Duration { return &cfg.GCInterval })},
+	{"gc-grace-period", "GC_GRACE_PERIOD", "minimum age of an unused image before it is removed", durationField(func(cfg *Config) *time.Duration { return &cfg.GCGracePeriod })},
+	{"gc-dry-run", "GC_DRY_RUN", "only report unused images instead of removing them", boolField(func(cfg *Config) *bool { return &cfg.GCDryRun })},
+	{"jwt-algorithm", "JWT_ALGORITHM", "JWT signing algorithm: HS256 or RS256", stringField(func(cfg *Config) *string { return &cfg.JWTAlgorithm })},
+	{"jwt-secret", "JWT_SECRET", "HS256 signing secret; a random one is generated when empty", stringField(func(cfg *Config) *string { return &cfg.JWTSecret })},
+	{"jwt-private-key", "JWT_PRIVATE_KEY_FILE", "PEM file with the RS256 private key", stringField(func(cfg *Config) *string { return &cfg.JWTPrivateKeyFile })},
+	{"access-token-ttl", "ACCESS_TOKEN_TTL", "lifetime of access tokens", durationField(func(cfg *Config) *time.Duration { return &cfg.AccessTokenTTL })},
+	{"refresh-token-ttl", "REFRESH_TOKEN_TTL", "lifetime of refresh tokens", durationField(func(cfg *Config) *time.Duration { return &cfg.RefreshTokenTTL })},
+	{"admin-emails", "ADMIN_EMAILS", "comma-separated emails that register as admins", stringListField(func(cfg *Config) *[]string { return &cfg.AdminEmails })},
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+func (v stringValue) Set(value string) error {
+	*v.p = value
+	return nil
+}
+
+func stringField(field func(cfg *Config) *string) func(cfg *Config) flag.Value {
+	return func(cfg *Config) flag.Value { return stringValue{field(cfg)} }
+}
+
+type stringListValue struct{ p *[]string }
+
+func (v stringListValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+func (v stringListValue) Set(value string) error {
+	*v.p = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v.p = append(*v.p, item)
+		}
+	}
+
+	return nil
+}
+
+func stringListField(field func(cfg *Config) *[]string) func(cfg *Config) flag.Value {
+	return func(cfg *Config) flag.Value { return stringListValue{field(cfg)} }
+}
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+
+func (v durationValue) Set(value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*v.p = duration
+	return nil
+}
+
+func durationField(field func(cfg *Config) *time.Duration) func(cfg *Config) flag.Value {
+	return func(cfg *Config) flag.Value { return durationValue{field(cfg)} }
+}
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.p)
+}
+
+func (v boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	*v.p = parsed
+	return nil
+}
+
+// IsBoolFlag lets the flag be given without a value.
+func (v boolValue) IsBoolFlag() bool {
+	return true
+}
+
+func boolField(field func(cfg *Config) *bool) func(cfg *Config) flag.Value {
+	return func(cfg *Config) flag.Value { return boolValue{field(cfg)} }
+}
+
+// ByteSize is a number of bytes. In configs it is written as an integer, optionally followed
+// by a binary KB, MB or GB suffix: 512KB, 10MB.
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (s ByteSize) String() string {
+	for _, unit := range byteSizeUnits {
+		if s != 0 && s%unit.size == 0 {
+			return strconv.FormatInt(int64(s/unit.size), 10) + unit.suffix
+		}
+	}
+	return "0"
+}
+
+func (s *ByteSize) UnmarshalText(text []byte) error {
+	raw := strings.ToUpper(strings.TrimSpace(string(text)))
+
+	unit := ByteSize(1)
+	for _, candidate := range byteSizeUnits {
+		if strings.HasSuffix(raw, candidate.suffix) {
+			raw, unit = strings.TrimSpace(strings.TrimSuffix(raw, candidate.suffix)), candidate.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value < 0 || value > math.MaxInt64/int64(unit) {
+		return fmt.Errorf("invalid size %q", text)
+	}
+
+	*s = ByteSize(value) * unit
+	return nil
+}
+
+type byteSizeValue struct{ p *ByteSize }
+
+func (v byteSizeValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return v.p.String()
+}
+
+func (v byteSizeValue) Set(value string) error {
+	return v.p.UnmarshalText([]byte(value))
+}
+
+func byteSizeField(field func(cfg *Config) *ByteSize) func(cfg *Config) flag.Value {
+	return func(cfg *Config) flag.Value { return byteSizeValue{field(cfg)} }
+}
+
+// LoadConfig builds a Config from the command-line arguments (without the program name),
+// the environment and an optional YAML file, then validates it.
+func LoadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	flags := flag.NewFlagSet("api", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a YAML config file")
+	flagValues := make(map[string]*rawFlag, len(configFields))
+	for _, field := range configFields {
+		_, isBool := field.value(&Config{}).(interface{ IsBoolFlag() bool })
+		flagValues[field.flag] = &rawFlag{isBool: isBool}
+		flags.Var(flagValues[field.flag], field.flag, fmt.Sprintf("%s (env %s%s)", field.usage, envPrefix, field.env))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, field := range configFields {
+		if value, ok := os.LookupEnv(envPrefix + field.env); ok {
+			if err := field.value(&cfg).Set(value); err != nil {
+				return Config{}, fmt.Errorf("config: %s%s: %w", envPrefix, field.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, field := range configFields {
+			if field.flag == f.Name && flagErr == nil {
+				if err := field.value(&cfg).Set(flagValues[f.Name].value); err != nil {
+					flagErr = fmt.Errorf("config: -%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// rawFlag holds the text of a flag until the config file and the environment are applied.
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *rawFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func loadConfigFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (cfg Config) validate() error {
+	var problems []string
+
+	if cfg.Addr == "" {
+		problems = append(problems, "addr must not be empty")
+	}
+
+	switch cfg.Store {
+	case storeMongo:
+		if uri, err := url.Parse(cfg.MongoURI); err != nil || (uri.Scheme != "mongodb" && uri.Scheme != "mongodb+srv") {
+			problems = append(problems, fmt.Sprintf("mongo_uri %q must be a mongodb:// or mongodb+srv:// URI", cfg.MongoURI))
+		}
+
+		if cfg.MongoDatabase == "" {
+			problems = append(problems, "mongo_database must not be empty")
+		}
+	case storeMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("store %q must be %q or %q", cfg.Store, storeMongo, storeMemory))
+	}
+
+	if cfg.ImageCacheDir == "" {
+		problems = append(problems, "image_cache_dir must not be empty")
+	}
+
+	switch cfg.BlobStore {
+	case blobStoreLocal:
+		if cfg.StorageDir == "" {
+			problems = append(problems, "storage_dir must not be empty")
+		}
+	case blobStoreGridFS:
+		if cfg.Store != storeMongo {
+			problems = append(problems, fmt.Sprintf("blob_store %q needs store %q", blobStoreGridFS, storeMongo))
+		}
+	case blobStoreS3:
+		if endpoint, err := url.Parse(cfg.S3Endpoint); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			problems = append(problems, fmt.Sprintf("s3_endpoint %q must be an absolute http(s) URL", cfg.S3Endpoint))
+		}
+
+		if cfg.S3Bucket == "" {
+			problems = append(problems, "s3_bucket must not be empty")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("blob_store %q must be %q, %q or %q", cfg.BlobStore, blobStoreLocal, blobStoreGridFS, blobStoreS3))
+	}
+
+	if cfg.MaxUploadSize <= 0 {
+		problems = append(problems, "max_upload_size must be positive")
+	}
+
+	if cfg.ImageCacheSize <= 0 {
+		problems = append(problems, "image_cache_size must be positive")
+	}
+
+	if base, err := url.Parse(cfg.BaseURL); cfg.BaseURL != "" && (err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "") {
+		problems = append(problems, fmt.Sprintf("base_url %q must be an absolute http(s) URL", cfg.BaseURL))
+	}
+
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		problems = append(problems, "trusted_proxies: "+err.Error())
+	}
+
+	if _, err := newCORSPolicy(cfg); err != nil {
+		problems = append(problems, "cors: "+err.Error())
+	}
+
+	if cfg.GCInterval < 0 || cfg.GCGracePeriod < 0 {
+		problems = append(problems, "gc_interval and gc_grace_period must not be negative")
+	}
+
+	switch cfg.JWTAlgorithm {
+	case jwtHS256:
+	case jwtRS256:
+		if cfg.JWTPrivateKeyFile == "" {
+			problems = append(problems, "jwt_private_key_file is required for RS256")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("jwt_algorithm %q must be %q or %q", cfg.JWTAlgorithm, jwtHS256, jwtRS256))
+	}
+
+	if cfg.AccessTokenTTL <= 0 || cfg.RefreshTokenTTL <= 0 {
+		problems = append(problems, "access_token_ttl and refresh_token_ttl must be positive")
+	}
+
+	for _, email := range cfg.AdminEmails {
+		if _, ok := normalizeEmail(email); !ok {
+			problems = append(problems, fmt.Sprintf("admin_emails: %q is not a valid email", email))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
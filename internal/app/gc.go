@@ -0,0 +1,173 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Totals of the garbage collection since the start of the process, served at /debug/vars.
+var (
+	gcRemovedImages  = expvar.NewInt("gc_removed_images")
+	gcReclaimedBytes = expvar.NewInt("gc_reclaimed_bytes")
+)
+
+// imageCollector removes uploaded images that no card shows. Editing or deleting a card leaves
+// the storage alone, so an image it no longer shows stays there until collected.
+type imageCollector struct {
+	images   ImageRepository
+	blobs    BlobStore
+	cards    CardRepository
+	variants variantCache
+	// grace keeps images that were uploaded recently and are not yet attached to a card.
+	grace  time.Duration
+	dryRun bool
+}
+
+// collect runs a single pass and returns how many images were (or, in a dry run, would be)
+// removed and how many bytes that reclaims.
+func (c imageCollector) collect(ctx context.Context) (int, int64, error) {
+	blobs, err := c.blobs.List(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var (
+		removed   int
+		reclaimed int64
+		cutoff    = time.Now().Add(-c.grace)
+	)
+	for _, blob := range blobs {
+		if blob.ModTime.After(cutoff) || !validImageName(blob.Name) {
+			continue
+		}
+
+		if c.dryRun {
+			used, err := c.cards.UsesImage(ctx, blob.Name)
+			if err != nil {
+				return removed, reclaimed, err
+			}
+			if !used {
+				log.Printf("gc: would remove %s (%d bytes)", blob.Name, blob.Size)
+				removed++
+				reclaimed += blob.Size
+			}
+			continue
+		}
+
+		err = removeImage(ctx, c.images, c.blobs, c.cards, c.variants, blob.Name)
+		if errors.Is(err, errImageInUse) || errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return removed, reclaimed, err
+		}
+
+		log.Printf("gc: removed %s (%d bytes)", blob.Name, blob.Size)
+		removed++
+		reclaimed += blob.Size
+		gcRemovedImages.Add(1)
+		gcReclaimedBytes.Add(blob.Size)
+	}
+
+	if c.dryRun {
+		log.Printf("gc: dry run, %d unused images, %d bytes", removed, reclaimed)
+	} else {
+		log.Printf("gc: removed %d images, reclaimed %d bytes", removed, reclaimed)
+	}
+
+	return removed, reclaimed, nil
+}
+
+// sweep runs collect every interval until ctx is done.
+func (c imageCollector) sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := c.collect(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// knowsCards reports whether the store of cfg knows every card that uses an image. A memory
+// store without a store file starts empty, so every image stored earlier would look unused;
+// collecting with it would wipe the storage.
+func knowsCards(cfg Config) error {
+	if cfg.Store == storeMemory && cfg.StoreFile == "" {
+		return errors.New("the memory store has no store_file, so the cards that use images are unknown")
+	}
+	return nil
+}
+
+// startSweeper runs the collector in the background every gc_interval until ctx is done. It
+// reports whether the sweeper was started.
+func startSweeper(ctx context.Context, store Store, blobs BlobStore, variants variantCache, cfg Config) bool {
+	if cfg.GCInterval <= 0 {
+		return false
+	}
+
+	if err := knowsCards(cfg); err != nil {
+		log.Printf("gc: background collection is off: %v", err)
+		return false
+	}
+
+	go newImageCollector(store, blobs, variants, cfg).sweep(ctx, cfg.GCInterval)
+	return true
+}
+
+// CollectImages runs one pass of the image garbage collection with the stores from cfg.
+func CollectImages(cfg Config) error {
+	if err := knowsCards(cfg); err != nil {
+		return fmt.Errorf("gc: %w", err)
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := blobs.Close(context.Background()); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	variants, err := newVariantCache(cfg.ImageCacheDir, cfg.ImageCacheSize)
+	if err != nil {
+		return err
+	}
+
+	collector := newImageCollector(store, blobs, variants, cfg)
+	_, _, err = collector.collect(context.Background())
+	return err
+}
+
+func newImageCollector(store Store, blobs BlobStore, variants variantCache, cfg Config) imageCollector {
+	return imageCollector{
+		images:   store.Images,
+		blobs:    blobs,
+		cards:    store.Cards,
+		variants: variants,
+		grace:    cfg.GCGracePeriod,
+		dryRun:   cfg.GCDryRun,
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSweeperFixture stores an old image that no card uses in a fresh local blob store.
+func newSweeperFixture(t *testing.T, storeFile string) (Store, localBlobs, variantCache, Config, string) {
+	t.Helper()
+
+	cfg := defaultConfig()
+	cfg.Store = storeMemory
+	cfg.StoreFile = storeFile
+	cfg.GCInterval = 10 * time.Millisecond
+	cfg.GCGracePeriod = time.Hour
+
+	store, err := newMemoryStore(cfg.StoreFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs, err := newLocalBlobs(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	variants, err := newVariantCache(t.TempDir(), cfg.ImageCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := strings.Repeat("ab", 32) + ".png"
+	if err = blobs.Put(context.Background(), name, "image/png", strings.NewReader("png"), 3); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err = os.Chtimes(filepath.Join(blobs.dir, name), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	return store, blobs, variants, cfg, name
+}
+
+func blobExists(t *testing.T, blobs localBlobs, name string) bool {
+	t.Helper()
+
+	_, err := os.Stat(filepath.Join(blobs.dir, name))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestStartSweeperKeepsImagesOfUnknownCards(t *testing.T) {
+	store, blobs, variants, cfg, name := newSweeperFixture(t, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if startSweeper(ctx, store, blobs, variants, cfg) {
+		t.Error("the sweeper started on a memory store without a store file")
+	}
+
+	time.Sleep(20 * cfg.GCInterval)
+	if !blobExists(t, blobs, name) {
+		t.Fatal("an image was collected although the cards using it are unknown")
+	}
+}
+
+func TestStartSweeperCollectsWithStoreFile(t *testing.T) {
+	store, blobs, variants, cfg, name := newSweeperFixture(t, filepath.Join(t.TempDir(), "db.json"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if !startSweeper(ctx, store, blobs, variants, cfg) {
+		t.Fatal("the sweeper did not start")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for blobExists(t, blobs, name) {
+		if time.Now().After(deadline) {
+			t.Fatal("the unused image was not collected")
+		}
+		time.Sleep(cfg.GCInterval)
+	}
+}
+
+func TestCollectImagesRefusesMemoryStoreWithoutFile(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Store = storeMemory
+	cfg.StorageDir = t.TempDir()
+	cfg.ImageCacheDir = t.TempDir()
+
+	if err := CollectImages(cfg); err == nil {
+		t.Fatal("CollectImages ran on a memory store without a store file")
+	}
+}
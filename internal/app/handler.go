@@ -1,83 +1,175 @@
 package app
 
 import (
-	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
-	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	cardsCollectionName     = "cards"
-	cartCollectionName      = "cart"
-	favoritesCollectionName = "favorites"
 )
 
 type Card struct {
 	ID    string  `json:"id" bson:"_id"`
 	Name  string  `json:"name" bson:"name"`
 	Price float64 `json:"price" bson:"price"`
-	Img   string  `json:"img" bson:"img"`
+	// Img is stored as the id of an uploaded image and returned as a link to it; absolute URLs
+	// pointing elsewhere are kept as they are.
+	Img string `json:"img" bson:"img"`
 }
 
 type Order struct {
-	CreatedAt time.Time `json:"created_at" bson:"created_at"`
-	Cards     []Card    `json:"cards" bson:"cards"`
+	ID        string      `json:"id" bson:"_id"`
+	UserID    string      `json:"user_id,omitempty" bson:"user_id"`
+	Status    string      `json:"status" bson:"status" enums:"pending,paid,shipped,delivered,cancelled"`
+	CreatedAt time.Time   `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at" bson:"updated_at"`
+	Cards     []OrderItem `json:"cards" bson:"cards"`
+	Total     float64     `json:"total" bson:"total"`
+}
+
+func newOrder(userID string, items []OrderItem) Order {
+	now := time.Now()
+	order := Order{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Status:    orderPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Cards:     items,
+	}
+
+	for _, item := range items {
+		order.Total += item.Price * float64(item.Quantity)
+	}
+	order.Total = roundPrice(order.Total)
+
+	return order
+}
+
+// OrderItem is a card as it was when the order was placed, including its price.
+type OrderItem struct {
+	Card     `bson:",inline"`
+	Quantity int `json:"quantity" bson:"quantity"`
 }
 
 // AllCards godoc
 // @Summary      Получить массив карточек
+// @Description  Общее число найденных карточек возвращается в заголовке X-Total-Count.
+// @Description  Если есть следующая страница, курсор на неё передаётся в заголовках X-Next-Cursor и Link.
 // @Tags         cards
-// @Produce      json
+// @Produce      json,application/msgpack
 // @Content-Type application/json
+// @param        name      query string false "подстрока названия (без учёта регистра)"
+// @param        min_price query number false "минимальная цена"
+// @param        max_price query number false "максимальная цена"
+// @param        sort      query string false "поля сортировки через запятую, '-' — по убыванию: price,-name"
+// @param        limit     query int    false "размер страницы (1-1000)"
+// @param        offset    query int    false "сколько карточек пропустить"
+// @param        cursor    query string false "курсор из X-Next-Cursor предыдущей страницы"
 // @Success      200 {object} []Card
+// @Header       200 {integer} X-Total-Count "число карточек, подходящих под фильтры"
+// @Header       200 {string}  X-Next-Cursor "курсор следующей страницы"
+// @Failure      400 {object} Problem
 // @Router       /api/cards [get]
-func AllCards(db *mongo.Database) http.HandlerFunc {
+func AllCards(cards CardRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		query, err := parseCardQuery(request.URL.Query())
+		if err != nil {
+			writeQueryError(writer, err)
+			return
+		}
+
+		page, err := cards.Find(request.Context(), query)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writer.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+		if page.NextCursor != "" {
+			next := *request.URL
+			values := next.Query()
+			values.Set("cursor", page.NextCursor)
+			values.Del("offset")
+			next.RawQuery = values.Encode()
+
+			writer.Header().Set("X-Next-Cursor", page.NextCursor)
+			writer.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+		}
+
+		writeResponse(writer, request, http.StatusOK, resolveImages(baseURLFromContext(request.Context()), page.Cards))
+	}
+}
+
+// SearchCards godoc
+// @Summary      Полнотекстовый поиск карточек
+// @Description  Ищет по названию карточки. Если точных совпадений нет, выполняется поиск с учётом опечаток (fuzzy=true).
+// @Description  В highlight название экранировано как HTML, совпавшие слова обёрнуты в <mark>.
+// @Tags         cards
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        q     query string true  "поисковый запрос"
+// @param        limit query int    false "максимум результатов (1-100, по умолчанию 20)"
+// @Success      200 {object} []SearchHit
+// @Failure      400 {object} Problem
+// @Router       /api/cards/search [get]
+func SearchCards(cards CardRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		cursor, err := db.Collection(cardsCollectionName).Find(request.Context(), bson.D{})
+		values := request.URL.Query()
+		text := strings.TrimSpace(values.Get("q"))
+		if text == "" {
+			writeQueryError(writer, invalidField("q", "q must not be empty"))
+			return
+		}
+
+		limit, err := parseOptionalInt(values, "limit", 1, maxSearchLimit)
 		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+			writeQueryError(writer, err)
 			return
 		}
+		if limit == 0 {
+			limit = defaultSearchLimit
+		}
 
-		var data []Card
-		if err = cursor.All(request.Context(), &data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		hits, err := cards.Search(request.Context(), text, limit)
+		if err != nil {
+			writeInternalError(writer, err)
 			return
 		}
 
-		writeJSON(http.StatusOK, writer, data)
+		if hits == nil {
+			hits = []SearchHit{}
+		}
+
+		writeResponse(writer, request, http.StatusOK, resolveImages(baseURLFromContext(request.Context()), hits))
 	}
 }
 
 type CardRequest struct {
-	Name  string  `json:"name"`
-	Price float64 `json:"price"`
-	Img   string  `json:"img"`
+	Name  string  `json:"name" validate:"required,max=200"`
+	Price float64 `json:"price" validate:"gte=0,lte=1000000"`
+	Img   string  `json:"img" validate:"omitempty,max=2048,image"`
 }
 
 // PostCard godoc
 // @Summary      Создать карточку
 // @Tags         cards
-// @Accept       json
-// @Produce      json
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
 // @Content-Type application/json
 // @param        request body CardRequest true "body"
 // @Success      200 {object} Card
+// @Security     BearerAuth
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      400 {object} Problem "некорректный JSON"
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
 // @Router       /api/cards [post]
-func PostCard(db *mongo.Database) http.HandlerFunc {
+func PostCard(cards CardRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var body CardRequest
 		if !handleRequest(writer, request, &body) {
@@ -89,92 +181,248 @@ func PostCard(db *mongo.Database) http.HandlerFunc {
 			ID:    id,
 			Name:  body.Name,
 			Price: body.Price,
-			Img:   body.Img,
+			Img:   imageKey(body.Img),
+		}
+
+		if err := cards.Create(request.Context(), card); err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusCreated, card.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
+
+// GetCard godoc
+// @Summary      Получить карточку
+// @Tags         cards
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @Success      200 {object} Card
+// @Failure      404 {object} Problem
+// @Router       /api/cards/{id} [get]
+func GetCard(cards CardRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		card, err := cards.Get(request.Context(), chi.URLParam(request, "id"))
+		if err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, card.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
+
+// PutCard godoc
+// @Summary      Заменить карточку
+// @Description  Копии карточки в избранном и корзине обновляются вместе с ней.
+// @Tags         cards
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @param        request body CardRequest true "body"
+// @Success      200 {object} Card
+// @Failure      404 {object} Problem
+// @Security     BearerAuth
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      400 {object} Problem "некорректный JSON"
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/cards/{id} [put]
+func PutCard(cards CardRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body CardRequest
+		if !handleRequest(writer, request, &body) {
+			return
+		}
+
+		card := Card{
+			ID:    chi.URLParam(request, "id"),
+			Name:  body.Name,
+			Price: body.Price,
+			Img:   imageKey(body.Img),
+		}
+
+		if err := cards.Update(request.Context(), card); err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, card.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
+
+// PatchCard godoc
+// @Summary      Изменить поля карточки
+// @Description  Тело запроса — JSON merge patch (RFC 7396): переданные поля заменяются, null сбрасывает поле.
+// @Description  Копии карточки в избранном и корзине обновляются вместе с ней.
+// @Tags         cards
+// @Accept       application/merge-patch+json,json,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @param        request body CardRequest true "body"
+// @Success      200 {object} Card
+// @Failure      400 {object} Problem
+// @Failure      404 {object} Problem
+// @Security     BearerAuth
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/cards/{id} [patch]
+func PatchCard(cards CardRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var patch interface{}
+		if !decodeRequest(writer, request, &patch, mediaMergePatch, mediaJSON, mediaMsgPack) {
+			return
 		}
 
-		_, err := db.Collection(cardsCollectionName).InsertOne(request.Context(), card)
+		id := chi.URLParam(request, "id")
+		card, err := cards.Get(request.Context(), id)
 		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+			writeStoreError(writer, err)
+			return
+		}
+
+		var current interface{}
+		if err = remarshal(CardRequest{Name: card.Name, Price: card.Price, Img: card.Img}, &current); err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		var body CardRequest
+		if err = remarshal(mergePatch(current, patch), &body); err != nil {
+			writeBodyError(writer, err)
 			return
 		}
 
-		writeJSON(http.StatusCreated, writer, card)
+		fields := validateRequest(body)
+		// The stored img may predate the rules of the image tag, e.g. a link to a file uploaded
+		// under its own name; it is only checked when the patch changes it.
+		if object, _ := patch.(map[string]interface{}); object["img"] == nil {
+			kept := fields[:0]
+			for _, field := range fields {
+				if field.Field != "img" {
+					kept = append(kept, field)
+				}
+			}
+			fields = kept
+		}
+		if len(fields) > 0 {
+			writeValidationError(writer, fields...)
+			return
+		}
+
+		card = Card{
+			ID:    id,
+			Name:  body.Name,
+			Price: body.Price,
+			Img:   imageKey(body.Img),
+		}
+
+		if err = cards.Update(request.Context(), card); err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, card.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
+
+// DeleteCard godoc
+// @Summary      Удалить карточку
+// @Description  Карточка также удаляется из избранного и корзины; оформленные заказы не меняются.
+// @Tags         cards
+// @param        id path string true "id"
+// @Success      204
+// @Failure      404 {object} Problem
+// @Security     BearerAuth
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Router       /api/cards/{id} [delete]
+func DeleteCard(cards CardRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := cards.Delete(request.Context(), chi.URLParam(request, "id")); err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
 	}
 }
 
+type favoriteRequest struct {
+	ID string `json:"id" validate:"required,max=128"`
+}
+
 // PostFavorite godoc
 // @Summary      добавить карточку в избранное
+// @Description  Карточка берётся из каталога по id.
 // @Tags         favorite
-// @Accept       json
-// @Produce      json
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
 // @Content-Type application/json
-// @param        request body Card true "body"
+// @param        request body favoriteRequest true "body"
 // @Success      200 {object} Card
+// @Failure      409 {object} Problem
+// @Failure      422 {object} Problem "карточки нет в каталоге или поля тела не прошли проверку"
+// @Failure      400 {object} Problem "некорректный JSON"
 // @Router       /api/cards/favorite [post]
-func PostFavorite(db *mongo.Database) http.HandlerFunc {
+func PostFavorite(cards CardRepository, favorites FavoriteRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		var body Card
+		var body favoriteRequest
 		if !handleRequest(writer, request, &body) {
 			return
 		}
 
-		_, err := db.Collection(favoritesCollectionName).InsertOne(request.Context(), body)
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		card, ok := resolveCard(writer, request, cards, "id", body.ID)
+		if !ok {
+			return
+		}
+
+		if err := favorites.Add(request.Context(), userFromContext(request.Context()), card); err != nil {
+			writeStoreError(writer, err)
 			return
 		}
 
-		writeJSON(http.StatusCreated, writer, body)
+		writeResponse(writer, request, http.StatusCreated, card.withImageURLs(baseURLFromContext(request.Context())))
 	}
 }
 
 // GetFavorites godoc
 // @Summary      Получить массив карточек из избранного
 // @Tags         favorite
-// @Produce      json
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Produce      json,application/msgpack
 // @Content-Type application/json
 // @Success      200 {object} []Card
 // @Router       /api/cards/favorite [get]
-func GetFavorites(db *mongo.Database) http.HandlerFunc {
+func GetFavorites(favorites FavoriteRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		cursor, err := db.Collection(favoritesCollectionName).Find(request.Context(), bson.D{})
+		data, err := favorites.All(request.Context(), userFromContext(request.Context()))
 		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+			writeInternalError(writer, err)
 			return
 		}
 
-		var data []Card
-		if err = cursor.All(request.Context(), &data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		writeJSON(http.StatusOK, writer, data)
+		writeResponse(writer, request, http.StatusOK, resolveImages(baseURLFromContext(request.Context()), data))
 	}
 }
 
 // DeleteFavorite godoc
 // @Summary      удалить карточку из избранного
 // @Tags         favorite
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
 // @param        id path string true "id"
 // @Success      204
 // @Router       /api/cards/favorite/{id} [delete]
-func DeleteFavorite(db *mongo.Database) http.HandlerFunc {
+func DeleteFavorite(favorites FavoriteRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		filter := bson.D{{Key: "_id", Value: chi.URLParam(request, "id")}}
-		result, err := db.Collection(favoritesCollectionName).DeleteOne(request.Context(), filter)
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if result.DeletedCount == 0 {
-			writer.WriteHeader(http.StatusNotFound)
+		if err := favorites.Delete(request.Context(), userFromContext(request.Context()), chi.URLParam(request, "id")); err != nil {
+			writeStoreError(writer, err)
 			return
 		}
 
@@ -182,78 +430,151 @@ func DeleteFavorite(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+type cartItemRequest struct {
+	ID string `json:"id" validate:"required,max=128"`
+	// Quantity defaults to 1.
+	Quantity int `json:"quantity" validate:"gte=0,lte=999"`
+}
+
 // PostCart godoc
 // @Summary      добавить карточку в корзину
+// @Description  Карточка и её цена берутся из каталога по id. Если карточка уже в корзине, её количество увеличивается.
 // @Tags         cart
-// @Accept       json
-// @Produce      json
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
 // @Content-Type application/json
-// @param        request body Card true "body"
-// @Success      200 {object} Card
+// @param        request body cartItemRequest true "body"
+// @Success      201 {object} CartItem
+// @Failure      400 {object} Problem
+// @Failure      422 {object} Problem "карточки нет в каталоге или превышено количество или поля тела не прошли проверку"
 // @Router       /api/cards/cart [post]
-func PostCart(db *mongo.Database) http.HandlerFunc {
+func PostCart(cards CardRepository, cart CartRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		var body Card
+		var body cartItemRequest
 		if !handleRequest(writer, request, &body) {
 			return
 		}
 
-		_, err := db.Collection(cartCollectionName).InsertOne(request.Context(), body)
+		if body.Quantity == 0 {
+			body.Quantity = 1
+		}
+
+		card, ok := resolveCard(writer, request, cards, "id", body.ID)
+		if !ok {
+			return
+		}
+
+		item, err := cart.Add(request.Context(), userFromContext(request.Context()), card, body.Quantity)
 		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+			writeStoreError(writer, err)
 			return
 		}
 
-		writeJSON(http.StatusCreated, writer, body)
+		writeResponse(writer, request, http.StatusCreated, item.withImageURLs(baseURLFromContext(request.Context())))
 	}
 }
 
 // GetCart godoc
-// @Summary      Получить массив карточек из корзины
+// @Summary      Получить корзину
 // @Tags         cart
-// @Produce      json
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Produce      json,application/msgpack
 // @Content-Type application/json
-// @Success      200 {object} []Card
+// @Success      200 {object} CartSummary
 // @Router       /api/cards/cart [get]
-func GetCart(db *mongo.Database) http.HandlerFunc {
+func GetCart(cart CartRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		cursor, err := db.Collection(cartCollectionName).Find(request.Context(), bson.D{})
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		writeCart(writer, request, cart)
+	}
+}
+
+func writeCart(writer http.ResponseWriter, request *http.Request, cart CartRepository) {
+	items, err := cart.All(request.Context(), userFromContext(request.Context()))
+	if err != nil {
+		writeInternalError(writer, err)
+		return
+	}
+
+	writeResponse(writer, request, http.StatusOK, newCartSummary(resolveImages(baseURLFromContext(request.Context()), items)))
+}
+
+// cartQuantityRequest holds exactly one of Delta and Quantity.
+type cartQuantityRequest struct {
+	// Delta is added to the quantity; negative values decrease it.
+	Delta *int `json:"delta,omitempty" validate:"required_without=Quantity,excluded_with=Quantity,omitempty,gte=-999,lte=999"`
+	// Quantity replaces the quantity.
+	Quantity *int `json:"quantity,omitempty" validate:"omitempty,gte=0,lte=999"`
+}
+
+// PatchCart godoc
+// @Summary      изменить количество карточки в корзине
+// @Description  Передайте либо delta (например 1 или -1), либо quantity. Позиция с количеством 0 удаляется из корзины.
+// @Tags         cart
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @param        request body cartQuantityRequest true "body"
+// @Success      200 {object} CartSummary
+// @Failure      400 {object} Problem
+// @Failure      404 {object} Problem
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/cards/cart/{id} [patch]
+func PatchCart(cart CartRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body cartQuantityRequest
+		if !handleRequest(writer, request, &body) {
 			return
 		}
 
-		var data []Card
-		if err = cursor.All(request.Context(), &data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		userID, id := userFromContext(request.Context()), chi.URLParam(request, "id")
+
+		var err error
+		if body.Delta != nil {
+			err = cart.Adjust(request.Context(), userID, id, *body.Delta)
+		} else {
+			err = cart.SetQuantity(request.Context(), userID, id, *body.Quantity)
+		}
+
+		if err != nil {
+			writeStoreError(writer, err)
 			return
 		}
 
-		writeJSON(http.StatusOK, writer, data)
+		writeCart(writer, request, cart)
 	}
 }
 
 // DeleteCart godoc
 // @Summary      удалить карточку из корзины
 // @Tags         cart
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
 // @param        id path string true "id"
 // @Success      204
 // @Router       /api/cards/cart/{id} [delete]
-func DeleteCart(db *mongo.Database) http.HandlerFunc {
+func DeleteCart(cart CartRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		filter := bson.D{{Key: "_id", Value: chi.URLParam(request, "id")}}
-		result, err := db.Collection(cartCollectionName).DeleteOne(request.Context(), filter)
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		if err := cart.Delete(request.Context(), userFromContext(request.Context()), chi.URLParam(request, "id")); err != nil {
+			writeStoreError(writer, err)
 			return
 		}
 
-		if result.DeletedCount == 0 {
-			writer.WriteHeader(http.StatusNotFound)
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ClearCart godoc
+// @Summary      очистить корзину
+// @Tags         cart
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Success      204
+// @Router       /api/cards/cart [delete]
+func ClearCart(cart CartRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := cart.Clear(request.Context(), userFromContext(request.Context())); err != nil {
+			writeInternalError(writer, err)
 			return
 		}
 
@@ -261,220 +582,170 @@ func DeleteCart(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
-type orderResponse struct {
-	CreatedAt string `json:"created_at"`
-	Cards     []Card `json:"cards"`
+// Checkout godoc
+// @Summary      оформить заказ из корзины
+// @Description  Создаёт заказ из всех позиций корзины по их текущим ценам и очищает корзину — атомарно.
+// @Tags         cart
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @Success      201 {object} Order
+// @Failure      409 {object} Problem "корзина пуста"
+// @Router       /api/cards/cart/checkout [post]
+func Checkout(orders OrderRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		userID := userFromContext(request.Context())
+		order, err := orders.Checkout(request.Context(), userID, func(cart []CartItem) Order {
+			items := make([]OrderItem, 0, len(cart))
+			for _, item := range cart {
+				items = append(items, OrderItem{Card: item.Card, Quantity: item.Quantity})
+			}
+
+			return newOrder(userID, items)
+		})
+		if err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusCreated, order.withImageURLs(baseURLFromContext(request.Context())))
+	}
 }
 
 // GetOrders godoc
 // @Summary      Получить массив карточек заказов
+// @Description  Заказы группируются по дню, неделе (с понедельника) или месяцу создания в часовом поясе tz;
+// @Description  группы и заказы внутри них идут от новых к старым.
 // @Tags         order
-// @Produce      json
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Produce      json,application/msgpack
 // @Content-Type application/json
+// @param        tz query string false "часовой пояс IANA, например Europe/Moscow" default(UTC)
+// @param        granularity query string false "период группировки" Enums(day, week, month) default(day)
+// @param        from      query string false "заказы не раньше: дата YYYY-MM-DD (в поясе tz) или время RFC 3339"
+// @param        to        query string false "заказы раньше этого времени; дата YYYY-MM-DD включает весь день"
+// @param        status    query string false "статусы через запятую: pending,paid"
+// @param        min_total query number false "минимальная сумма заказа"
+// @param        max_total query number false "максимальная сумма заказа"
+// @param        card_id   query string false "только заказы с этой карточкой"
+// @param        limit     query int    false "сколько заказов вернуть (1-1000)"
+// @param        offset    query int    false "сколько заказов пропустить"
 // @Success      200 {object} []orderResponse
+// @Header       200 {integer} X-Total-Count "число заказов, подходящих под фильтры"
+// @Failure      400 {object} Problem
 // @Router       /api/cards/order [get]
-func GetOrders(db *mongo.Database) http.HandlerFunc {
+func GetOrders(orders OrderRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		cursor, err := db.Collection("orders").Find(request.Context(), bson.D{})
+		values := request.URL.Query()
+		grouping, err := parseOrderGrouping(values)
 		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+			writeQueryError(writer, err)
 			return
 		}
 
-		var data []Order
-		if err = cursor.All(request.Context(), &data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		query, err := parseOrderQuery(values, grouping.Location)
+		if err != nil {
+			writeQueryError(writer, err)
 			return
 		}
 
-		result := make(map[string][]Card)
-		for _, item := range data {
-			key := item.CreatedAt.Format("02.01.2006")
-			result[key] = append(result[key], item.Cards...)
+		page, err := orders.Find(request.Context(), userFromContext(request.Context()), query)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
 		}
 
-		var response []orderResponse
-		for key, cards := range result {
-			response = append(response, orderResponse{
-				CreatedAt: key,
-				Cards:     cards,
-			})
-		}
+		writer.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+		if next := int64(query.Offset + len(page.Orders)); query.Limit > 0 && next < page.Total {
+			nextURL := *request.URL
+			values.Set("offset", strconv.FormatInt(next, 10))
+			nextURL.RawQuery = values.Encode()
 
-		sort.Slice(response, func(i, j int) bool {
-			return response[i].CreatedAt > response[j].CreatedAt
-		})
+			writer.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+		}
 
-		writeJSON(http.StatusOK, writer, response)
+		writeResponse(writer, request, http.StatusOK, resolveImages(baseURLFromContext(request.Context()), grouping.group(page.Orders)))
 	}
 }
 
+type orderItemRequest struct {
+	ID string `json:"id" validate:"required,max=128"`
+	// Quantity defaults to 1.
+	Quantity int `json:"quantity" validate:"gte=0,lte=999"`
+}
+
 type orderRequest struct {
-	Cards []Card `json:"cards"`
+	Cards []orderItemRequest `json:"cards" validate:"required,min=1,max=100,dive"`
 }
 
 // PostOrder godoc
 // @Summary      добавить карточку в список заказов
+// @Description  Карточки берутся из каталога по id, их цены фиксируются в заказе на момент оформления.
 // @Tags         order
-// @Accept       json
-// @Produce      json
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
 // @Content-Type application/json
 // @param        request body orderRequest true "body"
-// @Success      201
+// @Success      201 {object} Order
+// @Failure      400 {object} Problem
+// @Failure      422 {object} Problem "карточки нет в каталоге или поля тела не прошли проверку"
 // @Router       /api/cards/order [post]
-func PostOrder(db *mongo.Database) http.HandlerFunc {
+func PostOrder(cards CardRepository, orders OrderRepository) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var body orderRequest
 		if !handleRequest(writer, request, &body) {
 			return
 		}
 
-		order := Order{
-			CreatedAt: time.Now(),
-			Cards:     body.Cards,
-		}
-
-		_, err := db.Collection("orders").InsertOne(request.Context(), order)
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		writer.WriteHeader(http.StatusCreated)
-	}
-}
-
-type imageResponse struct {
-	URL string `json:"url"`
-}
-
-// UploadImage godoc
-// @Summary      Загрузить картинку
-// @Tags         storage
-// @Accept       multipart/form-data
-// @Produce      json
-// @param        file formData file true "file"
-// @Success      201 {object} imageResponse
-// @Router       /api/storage [post]
-func UploadImage() http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		file, header, err := request.FormFile("file")
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		data := make([]byte, header.Size)
-
-		if _, err = file.Read(data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if err = file.Close(); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if err = request.Body.Close(); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		savedFile, err := os.OpenFile("./storage/"+header.Filename, os.O_CREATE|os.O_WRONLY, os.ModePerm)
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		defer func() {
-			if err := savedFile.Close(); err != nil {
-				log.Println(err)
+		var items []OrderItem
+		positions := make(map[string]int)
+		for n, line := range body.Cards {
+			if line.Quantity == 0 {
+				line.Quantity = 1
 			}
-		}()
-
-		if _, err = savedFile.Write(data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		writer.Header().Set("Content-Type", "application/json")
-		writer.WriteHeader(http.StatusCreated)
-
-		response := imageResponse{
-			URL: "http://localhost:8080/api/storage/" + header.Filename,
-		}
-
-		bytes, err := json.Marshal(response)
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		if _, err = writer.Write(bytes); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-		}
-	}
-}
 
-func GetImage() http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		filename := chi.URLParam(request, "id")
-		file, err := os.OpenFile("./storage/"+filename, os.O_RDONLY, os.ModePerm)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				writer.WriteHeader(http.StatusNotFound)
-				return
+			if i, ok := positions[line.ID]; ok {
+				items[i].Quantity += line.Quantity
+				if items[i].Quantity > maxCartQuantity {
+					writeValidationError(writer, invalidField(fmt.Sprintf("cards[%d].quantity", n), fmt.Sprintf("the total quantity of the card must not exceed %d", maxCartQuantity)))
+					return
+				}
+				continue
 			}
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
-		}
 
-		defer func() {
-			if err := file.Close(); err != nil {
-				log.Println(err)
+			card, ok := resolveCard(writer, request, cards, fmt.Sprintf("cards[%d].id", n), line.ID)
+			if !ok {
+				return
 			}
-		}()
 
-		stats, err := file.Stat()
-		if err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-			return
+			positions[line.ID] = len(items)
+			items = append(items, OrderItem{Card: card, Quantity: line.Quantity})
 		}
 
-		data := make([]byte, stats.Size())
-		if _, err = file.Read(data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
+		order := newOrder(userFromContext(request.Context()), items)
+		if err := orders.Create(request.Context(), order); err != nil {
+			writeInternalError(writer, err)
 			return
 		}
 
-		contentType := ""
-		switch {
-		case strings.Contains(filename, "jpg"):
-			contentType = "image/jpeg"
-		case strings.Contains(filename, "png"):
-			contentType = "image/png"
-		}
+		writeResponse(writer, request, http.StatusCreated, order.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
 
-		writer.Header().Set("Content-Type", contentType)
-		writer.Header().Set("Content-Length", strconv.Itoa(int(stats.Size())))
-		writer.WriteHeader(http.StatusOK)
-		if _, err = writer.Write(data); err != nil {
-			log.Println(err)
-			writer.WriteHeader(http.StatusInternalServerError)
-		}
+// resolveCard looks up the catalogue card a client refers to by id in the body field. Unknown
+// ids get 422: the request is well-formed, but refers to something that does not exist.
+func resolveCard(writer http.ResponseWriter, request *http.Request, cards CardRepository, field, id string) (Card, bool) {
+	card, err := cards.Get(request.Context(), id)
+	if errors.Is(err, errNotFound) {
+		writeProblem(writer, http.StatusUnprocessableEntity, codeUnknownCard, fmt.Sprintf("card %q does not exist", id),
+			invalidField(field, "no card with this id"))
+		return Card{}, false
+	}
+	if err != nil {
+		writeInternalError(writer, err)
+		return Card{}, false
 	}
+
+	return card, true
 }
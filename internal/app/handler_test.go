@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPatchCardKeepsLegacyImage(t *testing.T) {
+	store, err := newMemoryStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cards made before images were content addressed keep a link to a file named by the client.
+	legacy := "http://localhost:8080/api/storage/cat.jpg"
+	if err = store.Cards.Create(context.Background(), Card{ID: "1", Name: "Cat", Price: 10, Img: legacy}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Patch("/api/cards/{id}", PatchCard(store.Cards))
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPatch, "/api/cards/1", strings.NewReader(body))
+		request.Header.Set("Content-Type", mediaMergePatch)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if recorder := patch(`{"price":5}`); recorder.Code != http.StatusOK {
+		t.Fatalf("patching the price: status %d, %s", recorder.Code, recorder.Body)
+	}
+	card, err := store.Cards.Get(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Price != 5 || card.Img != imageKey(legacy) {
+		t.Errorf("card = %+v, want price 5 and img %q", card, imageKey(legacy))
+	}
+
+	// An img the patch does set is still checked.
+	if recorder := patch(`{"img":"http://localhost:8080/api/storage/dog.jpg"}`); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("patching img to a storage link without an upload: status %d, want 422", recorder.Code)
+	}
+}
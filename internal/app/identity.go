@@ -0,0 +1,148 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	userIDHeader      = "X-User-ID"
+	sessionCookieName = "session_id"
+	sessionMaxAge     = 365 * 24 * 60 * 60
+	maxUserIDLength   = 128
+)
+
+type userIDKey struct{}
+
+// userFromContext returns the id of the user the request was made on behalf of.
+func userFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
+
+func withUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+// anonymousPrefix keeps the ids of anonymous callers apart from those of registered users,
+// so that a header or a cookie can never stand for an account.
+const anonymousPrefix = "anon:"
+
+// Identify works out who is calling, so that carts, favorites and orders are kept per user.
+// It must run after Authenticate. In order of preference the identity comes from:
+//   - the authenticated Principal (a valid bearer token);
+//   - the X-User-ID header;
+//   - the session_id cookie, which is issued on the first request that has none of the above.
+//
+// Ids from the header and the cookie are anonymous: they are prefixed with "anon:", and the id
+// of a registered user is refused, since acting as one takes a verified token.
+func Identify(users UserRepository) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			var userID string
+
+			principal, authenticated := principalFromContext(request.Context())
+			switch {
+			case authenticated:
+				userID = principal.UserID
+			case request.Header.Get(userIDHeader) != "":
+				claimed := request.Header.Get(userIDHeader)
+				if !validUserID(claimed) {
+					writeProblem(writer, http.StatusBadRequest, codeInvalidHeader, userIDHeader+" is not a valid user id",
+						invalidField(userIDHeader, fmt.Sprintf("must be 1 to %d printable characters without spaces", maxUserIDLength)))
+					return
+				}
+
+				registered, err := isRegistered(request.Context(), users, claimed)
+				if err != nil {
+					writeInternalError(writer, err)
+					return
+				}
+				if registered {
+					writeProblem(writer, http.StatusUnauthorized, codeUnauthorized,
+						"the user is registered, an access token is required to act on their behalf")
+					return
+				}
+
+				userID = anonymousID(claimed)
+			default:
+				if cookie, err := request.Cookie(sessionCookieName); err == nil && validUserID(cookie.Value) {
+					registered, err := isRegistered(request.Context(), users, cookie.Value)
+					if err != nil {
+						writeInternalError(writer, err)
+						return
+					}
+					if !registered {
+						userID = anonymousID(cookie.Value)
+						break
+					}
+				}
+
+				session := uuid.New().String()
+				userID = anonymousID(session)
+				http.SetCookie(writer, &http.Cookie{
+					Name:     sessionCookieName,
+					Value:    session,
+					Path:     "/",
+					MaxAge:   sessionMaxAge,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			handler.ServeHTTP(writer, request.WithContext(withUser(request.Context(), userID)))
+		})
+	}
+}
+
+// anonymousID puts the id an anonymous caller sent into the anonymous namespace. An id that is
+// already there, as returned in user_id of orders, is kept as is.
+func anonymousID(id string) string {
+	if strings.HasPrefix(id, anonymousPrefix) {
+		return id
+	}
+	return anonymousPrefix + id
+}
+
+// isRegistered reports whether id, with the anonymous prefix or without it, is the id of a
+// registered user.
+func isRegistered(ctx context.Context, users UserRepository, id string) (bool, error) {
+	_, err := users.Get(ctx, strings.TrimPrefix(id, anonymousPrefix))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, errNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func bearerToken(request *http.Request) string {
+	scheme, token, found := strings.Cut(request.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+func validUserID(userID string) bool {
+	if userID == "" || len(userID) > maxUserIDLength {
+		return false
+	}
+
+	for _, r := range userID {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+
+	return true
+}
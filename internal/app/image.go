@@ -0,0 +1,238 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Image is the metadata of an uploaded image; the content itself is kept by a BlobStore.
+type Image struct {
+	// ID is the name of the image in the BlobStore.
+	ID          string    `json:"id" bson:"_id"`
+	URL         string    `json:"url" bson:"-"`
+	Size        int64     `json:"size" bson:"size"`
+	ContentType string    `json:"content_type" bson:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at" bson:"uploaded_at"`
+	// UploadedBy is the id of the user who uploaded the image first; empty for images
+	// that were put into the storage some other way.
+	UploadedBy string `json:"uploaded_by,omitempty" bson:"uploaded_by,omitempty"`
+}
+
+func (i Image) withImageURLs(baseURL string) Image {
+	i.URL = imageURL(baseURL, i.ID)
+	return i
+}
+
+// ImageQuery selects a page of images, newest first.
+type ImageQuery struct {
+	Limit  int
+	Offset int
+}
+
+type ImagePage struct {
+	Images []Image
+	// Total is the number of images, regardless of Limit and Offset.
+	Total int64
+}
+
+var errImageInUse = errors.New("image is used by a card")
+
+func parseImageQuery(values url.Values) (ImageQuery, error) {
+	var (
+		query ImageQuery
+		err   error
+	)
+
+	if query.Limit, err = parseOptionalInt(values, "limit", 1, maxPageLimit); err != nil {
+		return ImageQuery{}, err
+	}
+
+	if query.Offset, err = parseOptionalInt(values, "offset", 0, -1); err != nil {
+		return ImageQuery{}, err
+	}
+
+	return query, nil
+}
+
+// paginate cuts a page out of images that are already sorted.
+func (q ImageQuery) paginate(images []Image) ImagePage {
+	page := ImagePage{Total: int64(len(images))}
+
+	if q.Offset >= len(images) {
+		images = nil
+	} else {
+		images = images[q.Offset:]
+	}
+
+	if q.Limit > 0 && len(images) > q.Limit {
+		images = images[:q.Limit]
+	}
+
+	page.Images = append(make([]Image, 0, len(images)), images...)
+	return page
+}
+
+// newerImage is the order of image listings: newest first, then by id.
+func newerImage(a, b Image) bool {
+	if !a.UploadedAt.Equal(b.UploadedAt) {
+		return a.UploadedAt.After(b.UploadedAt)
+	}
+
+	return a.ID < b.ID
+}
+
+// AllImages godoc
+// @Summary      Получить список загруженных картинок
+// @Tags         storage
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        limit  query int false "размер страницы (1-1000)"
+// @param        offset query int false "сколько картинок пропустить"
+// @Success      200 {object} []Image
+// @Header       200 {integer} X-Total-Count "число картинок"
+// @Security     BearerAuth
+// @Failure      400 {object} Problem
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Router       /api/storage [get]
+func AllImages(images ImageRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		values := request.URL.Query()
+		query, err := parseImageQuery(values)
+		if err != nil {
+			writeQueryError(writer, err)
+			return
+		}
+
+		page, err := images.Find(request.Context(), query)
+		if err != nil {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writer.Header().Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+		if next := int64(query.Offset + len(page.Images)); query.Limit > 0 && next < page.Total {
+			nextURL := *request.URL
+			values.Set("offset", strconv.FormatInt(next, 10))
+			nextURL.RawQuery = values.Encode()
+
+			writer.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+		}
+
+		writeResponse(writer, request, http.StatusOK, resolveImages(baseURLFromContext(request.Context()), page.Images))
+	}
+}
+
+// GetImageMeta godoc
+// @Summary      Получить сведения о картинке
+// @Tags         storage
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @Success      200 {object} Image
+// @Security     BearerAuth
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      404 {object} Problem
+// @Router       /api/storage/{id}/meta [get]
+func GetImageMeta(images ImageRepository, blobs BlobStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		image, err := describeImage(request.Context(), images, blobs, chi.URLParam(request, "id"))
+		if err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, image.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
+
+// describeImage returns the metadata of an image. Images stored before metadata was tracked
+// are described from the BlobStore alone.
+func describeImage(ctx context.Context, images ImageRepository, blobs BlobStore, id string) (Image, error) {
+	if !validImageName(id) {
+		return Image{}, errNotFound
+	}
+
+	image, err := images.Get(ctx, id)
+	if !errors.Is(err, errNotFound) {
+		return image, err
+	}
+
+	blob, err := blobs.Open(ctx, id)
+	if err != nil {
+		return Image{}, err
+	}
+	if err = blob.Close(); err != nil {
+		log.Println(err)
+	}
+
+	return Image{ID: id, Size: blob.Size, ContentType: blob.ContentType, UploadedAt: blob.ModTime}, nil
+}
+
+// DeleteImage godoc
+// @Summary      Удалить картинку
+// @Description  Картинку, которая указана в карточке, удалить нельзя.
+// @Tags         storage
+// @param        id path string true "id"
+// @Success      204
+// @Security     BearerAuth
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      404 {object} Problem
+// @Failure      409 {object} Problem "картинка используется карточкой"
+// @Router       /api/storage/{id} [delete]
+func DeleteImage(images ImageRepository, blobs BlobStore, cards CardRepository, variants variantCache) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		id := chi.URLParam(request, "id")
+		if err := removeImage(request.Context(), images, blobs, cards, variants, id); err != nil {
+			if errors.Is(err, errImageInUse) {
+				writeProblem(writer, http.StatusConflict, codeImageInUse, err.Error())
+				return
+			}
+			writeStoreError(writer, err)
+			return
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// removeImage deletes an image that no card refers to, together with its metadata and variants.
+func removeImage(ctx context.Context, images ImageRepository, blobs BlobStore, cards CardRepository, variants variantCache, id string) error {
+	if !validImageName(id) {
+		return errNotFound
+	}
+
+	used, err := cards.UsesImage(ctx, id)
+	if err != nil {
+		return err
+	}
+	if used {
+		return errImageInUse
+	}
+
+	blobErr := blobs.Delete(ctx, id)
+	if blobErr != nil && !errors.Is(blobErr, errNotFound) {
+		return blobErr
+	}
+
+	metaErr := images.Delete(ctx, id)
+	if metaErr != nil && !errors.Is(metaErr, errNotFound) {
+		return metaErr
+	}
+
+	if blobErr != nil && metaErr != nil {
+		return errNotFound
+	}
+
+	return variants.remove(id)
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"bytes"
+	"io"
+)
+
+// sniffLength is how much of a file detectImageType needs to look at.
+const sniffLength = 32
+
+// imageType is an image format accepted for upload.
+type imageType struct {
+	MIME      string
+	Extension string
+	matches   func(head []byte) bool
+}
+
+// imageTypes is the upload allowlist. Formats are recognised by their magic bytes only;
+// the client's file name and Content-Type are not trusted.
+var imageTypes = []imageType{
+	{"image/jpeg", ".jpg", func(head []byte) bool {
+		return bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF})
+	}},
+	{"image/png", ".png", func(head []byte) bool {
+		return bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n"))
+	}},
+	{"image/gif", ".gif", func(head []byte) bool {
+		return bytes.HasPrefix(head, []byte("GIF87a")) || bytes.HasPrefix(head, []byte("GIF89a"))
+	}},
+	{"image/webp", ".webp", func(head []byte) bool {
+		return len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP"))
+	}},
+	{"image/avif", ".avif", isAVIF},
+}
+
+// isAVIF looks for an ISO BMFF "ftyp" box whose major or one of the compatible brands is an AVIF one.
+func isAVIF(head []byte) bool {
+	if len(head) < 16 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return false
+	}
+
+	size := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	if size < 16 || size > len(head) {
+		size = len(head)
+	}
+
+	for offset := 8; offset+4 <= size; offset += 4 {
+		if offset == 12 {
+			// Skip the minor version.
+			continue
+		}
+
+		if brand := string(head[offset : offset+4]); brand == "avif" || brand == "avis" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// detectImageType returns the allowed image type head starts with.
+func detectImageType(head []byte) (imageType, bool) {
+	for _, candidate := range imageTypes {
+		if candidate.matches(head) {
+			return candidate, true
+		}
+	}
+
+	return imageType{}, false
+}
+
+// imageTypeByExtension maps the extension UploadImage gave a file back to its type.
+func imageTypeByExtension(extension string) (imageType, bool) {
+	for _, candidate := range imageTypes {
+		if candidate.Extension == extension {
+			return candidate, true
+		}
+	}
+
+	return imageType{}, false
+}
+
+// sniffImage reads the start of source and detects its type. The returned reader yields the
+// whole of source, including the bytes that were read.
+func sniffImage(source io.Reader) (imageType, io.Reader, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(source, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return imageType{}, nil, err
+	}
+	head = head[:n]
+
+	detected, ok := detectImageType(head)
+	if !ok {
+		return imageType{}, nil, errUnsupportedImage
+	}
+
+	return detected, io.MultiReader(bytes.NewReader(head), source), nil
+}
@@ -0,0 +1,435 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// maxVariantDimension caps the width and height a variant can be asked for.
+	maxVariantDimension = 2048
+	// maxSourcePixels protects against images that are small on disk but huge once decoded.
+	maxSourcePixels = 50_000_000
+	jpegQuality     = 85
+
+	fitContain = "contain"
+	fitCover   = "cover"
+	fitFill    = "fill"
+)
+
+var (
+	variantFits    = []string{fitContain, fitCover, fitFill}
+	variantFormats = map[string]imageType{}
+
+	errSourceTooLarge = errors.New("source image is too large to transform")
+)
+
+func init() {
+	for _, format := range []string{"jpeg", "png", "gif", "webp"} {
+		for _, candidate := range imageTypes {
+			if candidate.MIME == "image/"+format {
+				variantFormats[format] = candidate
+			}
+		}
+	}
+}
+
+// imageVariant is a resized or re-encoded version of a stored image. A zero Width or Height
+// follows from the other one and the aspect ratio of the source.
+type imageVariant struct {
+	Width  int
+	Height int
+	Fit    string
+	// Format is one of the keys of variantFormats; empty keeps the format of the source.
+	Format string
+}
+
+// parseImageVariant reads the variant parameters; ok is false when the original is asked for.
+func parseImageVariant(values url.Values) (variant imageVariant, ok bool, err error) {
+	if values.Get("w") == "" && values.Get("h") == "" && values.Get("fit") == "" && values.Get("format") == "" {
+		return imageVariant{}, false, nil
+	}
+
+	if variant.Width, err = parseOptionalInt(values, "w", 1, maxVariantDimension); err != nil {
+		return imageVariant{}, false, err
+	}
+
+	if variant.Height, err = parseOptionalInt(values, "h", 1, maxVariantDimension); err != nil {
+		return imageVariant{}, false, err
+	}
+
+	variant.Fit = values.Get("fit")
+	if variant.Fit == "" {
+		variant.Fit = fitContain
+	}
+	if !contains(variantFits, variant.Fit) {
+		return imageVariant{}, false, invalidField("fit", fmt.Sprintf("fit must be one of %v", variantFits))
+	}
+
+	if (variant.Fit == fitCover || variant.Fit == fitFill) && (variant.Width == 0 || variant.Height == 0) {
+		return imageVariant{}, false, invalidField("fit", fmt.Sprintf("fit=%s needs both w and h", variant.Fit))
+	}
+
+	variant.Format = values.Get("format")
+	if _, known := variantFormats[variant.Format]; variant.Format != "" && !known {
+		return imageVariant{}, false, invalidField("format", fmt.Sprintf("unknown format %q", variant.Format))
+	}
+
+	return variant, true, nil
+}
+
+// outputType returns the type of the variant of a source of the given type.
+func (v imageVariant) outputType(source string) (imageType, bool) {
+	if v.Format != "" {
+		return variantFormats[v.Format], true
+	}
+
+	for _, format := range variantFormats {
+		if format.MIME == source {
+			return format, true
+		}
+	}
+
+	// Sources that cannot be encoded (AVIF) are only transformed into an explicit format.
+	return imageType{}, false
+}
+
+// key identifies the variant of a particular version of a blob.
+func (v imageVariant) key(blob BlobInfo) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d\x00%d\x00%s\x00%s",
+		blob.Name, blob.Size, blob.ModTime.UnixNano(), v.Width, v.Height, v.Fit, v.Format)))
+	return hex.EncodeToString(hash[:])
+}
+
+// layout returns the size of the output image and the part of the source that is scaled into it.
+func (v imageVariant) layout(source image.Rectangle) (image.Point, image.Rectangle) {
+	sw, sh := source.Dx(), source.Dy()
+	w, h := v.Width, v.Height
+
+	switch {
+	case w == 0 && h == 0:
+		// Only the format changes; the size is kept within the cap.
+		w, h = sw, sh
+		if w > maxVariantDimension || h > maxVariantDimension {
+			return imageVariant{Width: maxVariantDimension, Height: maxVariantDimension, Fit: fitContain}.layout(source)
+		}
+	case v.Fit == fitFill:
+		return image.Pt(w, h), source
+	case v.Fit == fitCover:
+		// Crop the source to the aspect ratio of the output, keeping the centre.
+		crop := source
+		if sw*h > sh*w {
+			cw := sh * w / h
+			crop.Min.X += (sw - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := sw * h / w
+			crop.Min.Y += (sh - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+		return image.Pt(w, h), crop
+	case w == 0:
+		w = maxInt(1, sw*h/sh)
+		if w > maxVariantDimension {
+			// Clamping w alone would squash the image; shrink both sides instead.
+			return imageVariant{Width: maxVariantDimension, Height: maxVariantDimension, Fit: fitContain}.layout(source)
+		}
+	case h == 0:
+		h = maxInt(1, sh*w/sw)
+		if h > maxVariantDimension {
+			return imageVariant{Width: maxVariantDimension, Height: maxVariantDimension, Fit: fitContain}.layout(source)
+		}
+	default:
+		// contain: the largest size within w×h with the aspect ratio of the source.
+		if sw*h > sh*w {
+			h = maxInt(1, sh*w/sw)
+		} else {
+			w = maxInt(1, sw*h/sh)
+		}
+	}
+
+	return image.Pt(minInt(w, maxVariantDimension), minInt(h, maxVariantDimension)), source
+}
+
+// render decodes source, transforms it and encodes the result as output.
+func (v imageVariant) render(source io.ReadSeeker, output imageType, writer io.Writer) error {
+	config, _, err := image.DecodeConfig(source)
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxSourcePixels {
+		return errSourceTooLarge
+	}
+
+	if _, err = source.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	decoded, _, err := image.Decode(source)
+	if err != nil {
+		return err
+	}
+
+	size, crop := v.layout(decoded.Bounds())
+	scaled := image.NewNRGBA(image.Rectangle{Max: size})
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), decoded, crop, draw.Src, nil)
+
+	switch output.MIME {
+	case "image/jpeg":
+		return jpeg.Encode(writer, scaled, &jpeg.Options{Quality: jpegQuality})
+	case "image/png":
+		return png.Encode(writer, scaled)
+	case "image/gif":
+		return gif.Encode(writer, scaled, nil)
+	default:
+		return encodeWebP(writer, scaled)
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// variantCache keeps rendered variants as files, in a directory per image. The files take at
+// most limit bytes; past that the least recently used ones are evicted.
+type variantCache struct {
+	dir   string
+	limit int64
+	usage *cacheUsage
+}
+
+// cacheUsage tracks the files of a variantCache by their last use.
+type cacheUsage struct {
+	mu      sync.Mutex
+	total   int64
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// newVariantCache opens the cache in dir, taking the files already there into account with
+// their modification time as the last use.
+func newVariantCache(dir string, limit ByteSize) (variantCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return variantCache{}, err
+	}
+
+	cache := variantCache{dir: dir, limit: int64(limit), usage: &cacheUsage{entries: make(map[string]cacheEntry)}}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if matched, _ := filepath.Match(tempFilePattern, entry.Name()); matched {
+			// Left over by a render that was interrupted.
+			return os.Remove(path)
+		}
+
+		cache.usage.entries[path] = cacheEntry{size: info.Size(), used: info.ModTime()}
+		cache.usage.total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return variantCache{}, err
+	}
+
+	cache.evict("")
+	return cache, nil
+}
+
+// open returns the cached variant, rendering it first if needed. Concurrent requests for a
+// missing variant may render it more than once; the file is replaced atomically, so every one
+// of them reads a complete image.
+func (c variantCache) open(blob Blob, variant imageVariant, output imageType) (*os.File, error) {
+	dir := filepath.Join(c.dir, blob.Name)
+	path := filepath.Join(dir, variant.key(blob.BlobInfo)+output.Extension)
+	if file, err := os.Open(path); !errors.Is(err, os.ErrNotExist) {
+		if err == nil {
+			c.touch(path)
+		}
+		return file, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	temp, err := os.CreateTemp(dir, tempFilePattern)
+	if err != nil {
+		return nil, err
+	}
+
+	err = variant.render(blob, output, temp)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		if removeErr := os.Remove(temp.Name()); removeErr != nil {
+			log.Println(removeErr)
+		}
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Println(closeErr)
+		}
+		return nil, err
+	}
+	c.add(path, info.Size())
+
+	return file, nil
+}
+
+func (c variantCache) touch(path string) {
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+
+	if entry, ok := c.usage.entries[path]; ok {
+		entry.used = time.Now()
+		c.usage.entries[path] = entry
+	}
+}
+
+// add records a freshly rendered file and evicts others to make room for it.
+func (c variantCache) add(path string, size int64) {
+	c.usage.mu.Lock()
+	previous := c.usage.entries[path]
+	c.usage.entries[path] = cacheEntry{size: size, used: time.Now()}
+	c.usage.total += size - previous.size
+	c.usage.mu.Unlock()
+
+	c.evict(path)
+}
+
+// evict removes the least recently used files, except keep, until the cache fits its limit.
+func (c variantCache) evict(keep string) {
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+
+	if c.usage.total <= c.limit {
+		return
+	}
+
+	paths := make([]string, 0, len(c.usage.entries))
+	for path := range c.usage.entries {
+		if path != keep {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return c.usage.entries[paths[i]].used.Before(c.usage.entries[paths[j]].used)
+	})
+
+	for _, path := range paths {
+		if c.usage.total <= c.limit {
+			break
+		}
+
+		// An open file stays readable after it is removed, so requests being served are not cut.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+			continue
+		}
+		c.usage.total -= c.usage.entries[path].size
+		delete(c.usage.entries, path)
+	}
+}
+
+// remove drops the variants of the image with the name.
+func (c variantCache) remove(name string) error {
+	dir := filepath.Join(c.dir, name)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	c.usage.mu.Lock()
+	defer c.usage.mu.Unlock()
+
+	for path, entry := range c.usage.entries {
+		if filepath.Dir(path) == dir {
+			c.usage.total -= entry.size
+			delete(c.usage.entries, path)
+		}
+	}
+
+	return nil
+}
+
+// serveVariant writes the variant of blob, rendering and caching it on first use.
+func serveVariant(writer http.ResponseWriter, request *http.Request, cache variantCache, blob Blob, variant imageVariant) {
+	output, ok := variant.outputType(blob.ContentType)
+	if !ok {
+		writeProblem(writer, http.StatusUnsupportedMediaType, codeUnsupportedImage,
+			fmt.Sprintf("%s images cannot be transformed without format", blob.ContentType))
+		return
+	}
+
+	file, err := cache.open(blob, variant, output)
+	if err != nil {
+		if errors.Is(err, errSourceTooLarge) || errors.Is(err, image.ErrFormat) {
+			writeProblem(writer, http.StatusUnprocessableEntity, codeUnprocessableImage, err.Error())
+			return
+		}
+		writeInternalError(writer, err)
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	stats, err := file.Stat()
+	if err != nil {
+		writeInternalError(writer, err)
+		return
+	}
+
+	writer.Header().Set("ETag", `"`+variant.key(blob.BlobInfo)+`"`)
+	writer.Header().Set("Content-Type", output.MIME)
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	if contentAddressedName.MatchString(blob.Name) {
+		writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+
+	http.ServeContent(writer, request, blob.Name, stats.ModTime(), file)
+}
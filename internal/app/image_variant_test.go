@@ -0,0 +1,33 @@
+package app
+
+import (
+	"image"
+	"testing"
+)
+
+func TestImageVariantLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		variant imageVariant
+		source  image.Rectangle
+		want    image.Point
+	}{
+		{"format only", imageVariant{}, image.Rect(0, 0, 800, 600), image.Pt(800, 600)},
+		{"format only over the cap", imageVariant{}, image.Rect(0, 0, 8000, 2000), image.Pt(2048, 512)},
+		{"width only", imageVariant{Width: 400, Fit: fitContain}, image.Rect(0, 0, 800, 600), image.Pt(400, 300)},
+		{"height only", imageVariant{Height: 300, Fit: fitContain}, image.Rect(0, 0, 800, 600), image.Pt(400, 300)},
+		{"height only on a wide source", imageVariant{Height: 2048, Fit: fitContain}, image.Rect(0, 0, 4000, 1000), image.Pt(2048, 512)},
+		{"width only on a tall source", imageVariant{Width: 2048, Fit: fitContain}, image.Rect(0, 0, 1000, 4000), image.Pt(512, 2048)},
+		{"contain", imageVariant{Width: 400, Height: 400, Fit: fitContain}, image.Rect(0, 0, 800, 600), image.Pt(400, 300)},
+		{"fill", imageVariant{Width: 400, Height: 400, Fit: fitFill}, image.Rect(0, 0, 800, 600), image.Pt(400, 400)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _ := test.variant.layout(test.source)
+			if got != test.want {
+				t.Errorf("layout(%v) = %v, want %v", test.source.Size(), got, test.want)
+			}
+		})
+	}
+}
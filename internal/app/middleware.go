@@ -1,28 +1,179 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func Cors(handler http.Handler) http.Handler {
-	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		ref := `*`
-		if refURL, err := url.Parse(request.Referer()); err == nil {
-			ref = fmt.Sprintf("%s://%s", refURL.Scheme, refURL.Host)
+// corsPolicy says which browser origins may call the API and with what. An empty origin list
+// turns CORS off: no Access-Control-* headers are sent.
+type corsPolicy struct {
+	origins        []string
+	methods        []string
+	headers        []string
+	exposedHeaders []string
+	credentials    bool
+	maxAge         time.Duration
+}
+
+var validOriginPattern = regexp.MustCompile(`^[a-z][a-z0-9+.-]*://[^/?#@*]*\*?[^/?#@*]*$`)
+
+// newCORSPolicy checks the CORS settings of cfg. An origin is either "*", any origin, or
+// scheme://host[:port] with at most one "*" standing for any run of host characters:
+// https://*.example.com, http://localhost:*.
+func newCORSPolicy(cfg Config) (corsPolicy, error) {
+	policy := corsPolicy{
+		methods:        cfg.CORSAllowedMethods,
+		headers:        cfg.CORSAllowedHeaders,
+		exposedHeaders: cfg.CORSExposedHeaders,
+		credentials:    cfg.CORSAllowCredentials,
+		maxAge:         cfg.CORSMaxAge,
+	}
+
+	for _, origin := range cfg.CORSAllowedOrigins {
+		origin = strings.ToLower(strings.TrimRight(origin, "/"))
+		if origin == "*" && cfg.CORSAllowCredentials {
+			return corsPolicy{}, errors.New(`origin "*" cannot be combined with credentials, list the origins or turn cors_allow_credentials off`)
 		}
-		writer.Header().Set("Content-Type", "application/json")
-		writer.Header().Set("Access-Control-Allow-Origin", ref)
-		writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		writer.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-		writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Requested-With")
+		if origin != "*" && !validOriginPattern.MatchString(origin) {
+			return corsPolicy{}, fmt.Errorf("%q is not an origin such as https://app.example.com or https://*.example.com", origin)
+		}
+		policy.origins = append(policy.origins, origin)
+	}
+
+	if policy.maxAge < 0 {
+		return corsPolicy{}, errors.New("max age must not be negative")
+	}
+
+	return policy, nil
+}
+
+// allowsOrigin reports whether origin matches one of the patterns of the policy.
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.origins {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+
+		prefix, suffix, ok := strings.Cut(pattern, "*")
+		if !ok || len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		if hostChars(origin[len(prefix) : len(origin)-len(suffix)]) {
+			return true
+		}
+	}
+
+	return false
+}
 
-		if request.Method == http.MethodOptions {
-			writer.WriteHeader(200)
-			return
+// hostChars reports whether s may stand in for the "*" of an origin pattern: letters, digits,
+// dots and dashes, so that it never reaches past the host or the port.
+func hostChars(s string) bool {
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			return false
 		}
+	}
+	return true
+}
+
+// allowsAny reports whether value is in list, ignoring case; "*" in the list allows any value.
+func allowsAny(list []string, value string) bool {
+	for _, item := range list {
+		if item == "*" || strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cors applies the CORS policy: it answers preflight requests itself and marks the responses
+// to allowed origins so that browsers hand them over to the calling page.
+func Cors(policy corsPolicy) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		if len(policy.origins) == 0 {
+			return handler
+		}
+
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			origin := request.Header.Get("Origin")
+			preflight := request.Method == http.MethodOptions && request.Header.Get("Access-Control-Request-Method") != ""
+
+			header := writer.Header()
+			// The answer depends on the origin unless every origin gets the same "*".
+			if !allowsAny(policy.origins, "*") {
+				header.Add("Vary", "Origin")
+			}
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+
+			if !policy.allowsOrigin(origin) {
+				if preflight {
+					writeProblem(writer, http.StatusForbidden, codeForbidden, "origin "+origin+" is not allowed")
+					return
+				}
+				// Browsers keep the response from the page; other clients are not CORS's business.
+				handler.ServeHTTP(writer, request)
+				return
+			}
+
+			if allowsAny(policy.origins, "*") {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if policy.credentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				if len(policy.exposedHeaders) > 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(policy.exposedHeaders, ", "))
+				}
+				handler.ServeHTTP(writer, request)
+				return
+			}
+
+			method := request.Header.Get("Access-Control-Request-Method")
+			if !allowsAny(policy.methods, method) {
+				writeProblem(writer, http.StatusForbidden, codeForbidden, "method "+method+" is not allowed")
+				return
+			}
+
+			var requested []string
+			for _, name := range strings.Split(request.Header.Get("Access-Control-Request-Headers"), ",") {
+				if name = strings.TrimSpace(name); name == "" {
+					continue
+				}
+				if !allowsAny(policy.headers, name) {
+					writeProblem(writer, http.StatusForbidden, codeForbidden, "header "+name+" is not allowed")
+					return
+				}
+				requested = append(requested, name)
+			}
 
-		handler.ServeHTTP(writer, request)
-	})
+			header.Set("Access-Control-Allow-Methods", method)
+			if len(requested) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if policy.maxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge/time.Second)))
+			}
+			writer.WriteHeader(http.StatusNoContent)
+		})
+	}
 }
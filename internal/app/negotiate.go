@@ -0,0 +1,366 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	mediaJSON       = "application/json"
+	mediaMergePatch = "application/merge-patch+json"
+	mediaForm       = "application/x-www-form-urlencoded"
+	mediaMultipart  = "multipart/form-data"
+	mediaMsgPack    = "application/msgpack"
+)
+
+// msgPackAliases are the unregistered names of MessagePack that clients still send.
+var msgPackAliases = []string{"application/x-msgpack", "application/vnd.msgpack"}
+
+// bodyMediaTypes are the request bodies handleRequest accepts.
+var bodyMediaTypes = []string{mediaJSON, mediaForm, mediaMultipart, mediaMsgPack}
+
+// responseMediaTypes are the response bodies writeResponse can produce, the preferred first.
+var responseMediaTypes = []string{mediaJSON, mediaMsgPack}
+
+// parseMediaType parses a Content-Type or a media range of Accept, folding the MessagePack
+// aliases into mediaMsgPack.
+func parseMediaType(value string) (string, map[string]string, error) {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if contains(msgPackAliases, mediaType) {
+		mediaType = mediaMsgPack
+	}
+
+	return mediaType, params, nil
+}
+
+// readBody returns the body of request as JSON, whatever mediaType it was sent in, so that
+// all formats share decodeJSON: its unknown-field and type checks apply to each of them.
+func readBody(request *http.Request, mediaType string, data interface{}) (io.Reader, error) {
+	switch mediaType {
+	case mediaForm:
+		if err := request.ParseForm(); err != nil {
+			return nil, err
+		}
+		return formJSON(request.PostForm, reflect.TypeOf(data))
+	case mediaMultipart:
+		if err := request.ParseMultipartForm(maxRequestBodySize); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := request.MultipartForm.RemoveAll(); err != nil {
+				log.Println(err)
+			}
+		}()
+		return formJSON(request.MultipartForm.Value, reflect.TypeOf(data))
+	case mediaMsgPack:
+		var value interface{}
+		if err := msgpack.NewDecoder(request.Body).Decode(&value); err != nil {
+			return nil, err
+		}
+
+		body, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(body), nil
+	default:
+		return request.Body, nil
+	}
+}
+
+// formJSON converts form fields to the JSON object that decodes into a value of type t. Nested
+// fields are addressed as cards[0][id] or cards[0].id. Values become numbers and booleans where
+// t expects them and stay strings otherwise, so that decodeJSON reports them.
+func formJSON(values url.Values, t reflect.Type) (io.Reader, error) {
+	root := make(map[string]interface{})
+	for key, items := range values {
+		path := strings.FieldsFunc(key, func(r rune) bool { return r == '[' || r == ']' || r == '.' })
+		if len(path) == 0 {
+			continue
+		}
+
+		node := root
+		for _, name := range path[:len(path)-1] {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				if _, taken := node[name]; taken {
+					return nil, invalidField(key, "field "+key+" is given both as a value and as an object")
+				}
+				child = make(map[string]interface{})
+				node[name] = child
+			}
+			node = child
+		}
+
+		last := path[len(path)-1]
+		if _, taken := node[last]; taken {
+			return nil, invalidField(key, "field "+key+" is given both as a value and as an object")
+		}
+		node[last] = items
+	}
+
+	body, err := json.Marshal(formValue(root, t))
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(body), nil
+}
+
+// formValue shapes a node of the form tree after t: a leaf is a list of the submitted values,
+// anything else a map of the keys under it.
+func formValue(node interface{}, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch node := node.(type) {
+	case []string:
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			list := make([]interface{}, 0, len(node))
+			for _, item := range node {
+				list = append(list, formScalar(item, t.Elem()))
+			}
+			return list
+		}
+		return formScalar(node[0], t)
+	case map[string]interface{}:
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			return formList(node, t.Elem())
+		}
+
+		object := make(map[string]interface{}, len(node))
+		for name, child := range node {
+			var childType reflect.Type
+			if t != nil && t.Kind() == reflect.Struct {
+				childType = jsonFieldType(t, name)
+			}
+			object[name] = formValue(child, childType)
+		}
+		return object
+	default:
+		return node
+	}
+}
+
+// formList turns cards[0], cards[1]... into a list ordered by index. Keys that are not indexes
+// leave the node an object, which decodeJSON then rejects as not being an array.
+func formList(node map[string]interface{}, elem reflect.Type) interface{} {
+	indexes := make([]int, 0, len(node))
+	for name := range node {
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 0 {
+			return formValue(node, nil)
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	list := make([]interface{}, 0, len(indexes))
+	for _, index := range indexes {
+		list = append(list, formValue(node[strconv.Itoa(index)], elem))
+	}
+
+	return list
+}
+
+func formScalar(value string, t reflect.Type) interface{} {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.String {
+		return value
+	}
+
+	// An empty input leaves a number or a flag unset.
+	if value == "" {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case reflect.Bool:
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			return parsed
+		}
+	}
+
+	return value
+}
+
+// jsonFieldType returns the type of the field of struct t that encoding/json decodes name into,
+// or nil if there is none.
+func jsonFieldType(t reflect.Type, name string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			if found := jsonFieldType(field.Type, name); found != nil {
+				return found
+			}
+			continue
+		}
+
+		if tag == name || (tag == "" && strings.EqualFold(field.Name, name)) {
+			return field.Type
+		}
+	}
+
+	return nil
+}
+
+// negotiate picks the offer the Accept header prefers, honoring q-values and the most specific
+// matching range. A missing header accepts the first offer.
+func negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// specificity is 0 for */*, 1 for type/*, 2 for an exact match.
+		q, specificity := 0.0, -1
+		for _, item := range strings.Split(accept, ",") {
+			mediaType, params, err := parseMediaType(strings.TrimSpace(item))
+			if err != nil {
+				continue
+			}
+
+			level := -1
+			switch {
+			case mediaType == offer:
+				level = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+				level = 1
+			case mediaType == "*/*":
+				level = 0
+			}
+			if level <= specificity {
+				continue
+			}
+
+			specificity, q = level, 1
+			if raw, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(raw, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+type mediaTypeKey struct{}
+
+// responseMediaType returns the format Negotiate chose for the response, JSON by default.
+func responseMediaType(ctx context.Context) string {
+	if mediaType, ok := ctx.Value(mediaTypeKey{}).(string); ok {
+		return mediaType
+	}
+	return mediaJSON
+}
+
+// Negotiate picks the response format from offers by the Accept header, answering 406 before
+// the handler runs when none is acceptable.
+func Negotiate(offers ...string) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Add("Vary", "Accept")
+
+			mediaType, ok := negotiate(request.Header.Get("Accept"), offers)
+			if !ok {
+				writeProblem(writer, http.StatusNotAcceptable, codeNotAcceptable,
+					"Accept must allow one of "+strings.Join(offers, ", "))
+				return
+			}
+
+			handler.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), mediaTypeKey{}, mediaType)))
+		})
+	}
+}
+
+// writeResponse writes data in the format Negotiate chose: JSON or MessagePack. MessagePack
+// bodies are converted from the JSON encoding so that both have the same shape.
+func writeResponse(writer http.ResponseWriter, request *http.Request, code int, data interface{}) {
+	mediaType := responseMediaType(request.Context())
+
+	response, err := json.Marshal(data)
+	if err == nil && mediaType == mediaMsgPack {
+		response, err = jsonToMsgPack(response)
+	}
+	if err != nil {
+		writeInternalError(writer, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", mediaType)
+	writer.WriteHeader(code)
+	// The status is already sent, so a failed write can only be logged.
+	if _, err = writer.Write(response); err != nil {
+		log.Println(err)
+	}
+}
+
+func jsonToMsgPack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return msgpack.Marshal(msgPackNumbers(value))
+}
+
+// msgPackNumbers replaces the json.Numbers in value with integers where they are whole and
+// with floats otherwise.
+func msgPackNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+		float, err := value.Float64()
+		if err != nil {
+			return value.String()
+		}
+		return float
+	case []interface{}:
+		for i := range value {
+			value[i] = msgPackNumbers(value[i])
+		}
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = msgPackNumbers(value[key])
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", mediaJSON},
+		{"*/*", mediaJSON},
+		{"application/json", mediaJSON},
+		{"application/msgpack", mediaMsgPack},
+		{"application/x-msgpack", mediaMsgPack},
+		{"application/json;q=0.5, application/msgpack", mediaMsgPack},
+		{"text/html, */*;q=0.1", mediaJSON},
+		{"application/*", mediaJSON},
+		{"application/msgpack;q=0, */*", mediaJSON},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			called := false
+			handler := Negotiate(responseMediaTypes...)(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				called = true
+				writeResponse(writer, request, http.StatusCreated, map[string]int{"n": 1})
+			}))
+
+			request := httptest.NewRequest(http.MethodPost, "/api/cards", nil)
+			request.Header.Set("Accept", test.accept)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if test.want == "" {
+				// The handler, and with it any change it would make, must not run.
+				if called || recorder.Code != http.StatusNotAcceptable {
+					t.Fatalf("status %d, handler called: %v; want 406 before the handler", recorder.Code, called)
+				}
+				return
+			}
+
+			if recorder.Code != http.StatusCreated {
+				t.Fatalf("status %d, want 201", recorder.Code)
+			}
+			if got := recorder.Header().Get("Content-Type"); got != test.want {
+				t.Errorf("Content-Type = %q, want %q", got, test.want)
+			}
+			if got := recorder.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want Accept", got)
+			}
+		})
+	}
+}
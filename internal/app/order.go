@@ -0,0 +1,183 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	orderPending   = "pending"
+	orderPaid      = "paid"
+	orderShipped   = "shipped"
+	orderDelivered = "delivered"
+	orderCancelled = "cancelled"
+)
+
+var orderStatuses = []string{orderPending, orderPaid, orderShipped, orderDelivered, orderCancelled}
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Delivered and cancelled orders are final.
+var orderTransitions = map[string][]string{
+	orderPending: {orderPaid, orderCancelled},
+	orderPaid:    {orderShipped, orderCancelled},
+	orderShipped: {orderDelivered},
+}
+
+// customerTransitions are the moves the owner of an order may make; the rest are admin-only.
+var customerTransitions = []string{orderPaid, orderCancelled}
+
+var errInvalidTransition = errors.New("invalid order status transition")
+
+func canTransition(from, to string) bool {
+	return contains(orderTransitions[from], to)
+}
+
+// normalizeOrder fills in fields of orders stored before they were introduced.
+func normalizeOrder(order Order) Order {
+	if order.Status == "" {
+		order.Status = orderPending
+	}
+
+	if order.UpdatedAt.IsZero() {
+		order.UpdatedAt = order.CreatedAt
+	}
+
+	return order
+}
+
+// loadOrder fetches the order from the URL and checks that the caller owns it or is an admin.
+// Someone else's order is reported as missing so that order ids cannot be probed.
+func loadOrder(writer http.ResponseWriter, request *http.Request, orders OrderRepository) (Order, bool) {
+	order, err := orders.Get(request.Context(), chi.URLParam(request, "id"))
+	if err != nil {
+		writeStoreError(writer, err)
+		return Order{}, false
+	}
+
+	if order.UserID != userFromContext(request.Context()) && !isAdmin(request) {
+		writeStoreError(writer, errNotFound)
+		return Order{}, false
+	}
+
+	return order, true
+}
+
+func isAdmin(request *http.Request) bool {
+	principal, ok := principalFromContext(request.Context())
+	return ok && effectiveRole(principal.Role) == roleAdmin
+}
+
+// GetOrder godoc
+// @Summary      Получить заказ
+// @Tags         order
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @Success      200 {object} Order
+// @Failure      404 {object} Problem
+// @Router       /api/orders/{id} [get]
+func GetOrder(orders OrderRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		order, ok := loadOrder(writer, request, orders)
+		if !ok {
+			return
+		}
+
+		writeResponse(writer, request, http.StatusOK, order.withImageURLs(baseURLFromContext(request.Context())))
+	}
+}
+
+type orderStatusRequest struct {
+	Status string `json:"status" enums:"paid,shipped,delivered,cancelled" validate:"required"`
+}
+
+// PostOrderStatus godoc
+// @Summary      Изменить статус заказа
+// @Description  Допустимые переходы: pending → paid → shipped → delivered; pending и paid → cancelled.
+// @Description  Владелец заказа может оплатить и отменить его, отправка и доставка доступны только администраторам.
+// @Tags         order
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Accept       json,x-www-form-urlencoded,mpfd,application/msgpack
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @param        request body orderStatusRequest true "body"
+// @Success      200 {object} Order
+// @Failure      403 {object} Problem
+// @Failure      404 {object} Problem
+// @Failure      409 {object} Problem "переход из текущего статуса невозможен"
+// @Failure      400 {object} Problem "некорректный JSON"
+// @Failure      422 {object} Problem "поля тела не прошли проверку"
+// @Router       /api/orders/{id}/status [post]
+func PostOrderStatus(orders OrderRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var body orderStatusRequest
+		if !handleRequest(writer, request, &body) {
+			return
+		}
+
+		changeOrderStatus(writer, request, orders, body.Status)
+	}
+}
+
+// CancelOrder godoc
+// @Summary      Отменить заказ
+// @Description  Отменить можно заказ в статусе pending или paid.
+// @Tags         order
+// @param        X-User-ID header string false "идентификатор пользователя (иначе — cookie session_id)"
+// @Produce      json,application/msgpack
+// @Content-Type application/json
+// @param        id path string true "id"
+// @Success      200 {object} Order
+// @Failure      404 {object} Problem
+// @Failure      409 {object} Problem "заказ уже отправлен, доставлен или отменён"
+// @Router       /api/orders/{id}/cancel [post]
+func CancelOrder(orders OrderRepository) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		changeOrderStatus(writer, request, orders, orderCancelled)
+	}
+}
+
+func changeOrderStatus(writer http.ResponseWriter, request *http.Request, orders OrderRepository, status string) {
+	if !contains(orderStatuses, status) {
+		writeValidationError(writer, invalidField("status", "status must be one of "+strings.Join(orderStatuses, ", ")))
+		return
+	}
+
+	order, ok := loadOrder(writer, request, orders)
+	if !ok {
+		return
+	}
+
+	if !contains(customerTransitions, status) && !isAdmin(request) {
+		writeProblem(writer, http.StatusForbidden, codeForbidden, fmt.Sprintf("only admins can set status %s", status))
+		return
+	}
+
+	if !canTransition(order.Status, status) {
+		writeProblem(writer, http.StatusConflict, codeInvalidTransition,
+			fmt.Sprintf("an order cannot go from %s to %s", order.Status, status))
+		return
+	}
+
+	now := time.Now()
+	if err := orders.SetStatus(request.Context(), order.ID, order.Status, status, now); err != nil {
+		// The order changed since it was loaded.
+		if errors.Is(err, errInvalidTransition) {
+			writeProblem(writer, http.StatusConflict, codeInvalidTransition, "the order status has just changed, reload the order")
+			return
+		}
+
+		writeInternalError(writer, err)
+		return
+	}
+
+	order.Status, order.UpdatedAt = status, now
+	writeResponse(writer, request, http.StatusOK, order.withImageURLs(baseURLFromContext(request.Context())))
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+const (
+	groupByDay   = "day"
+	groupByWeek  = "week"
+	groupByMonth = "month"
+)
+
+var orderGranularities = []string{groupByDay, groupByWeek, groupByMonth}
+
+// orderResponse is a group of orders created within the same period.
+type orderResponse struct {
+	// CreatedAt labels the period: "02.01.2006" for a day or the Monday of a week, "01.2006" for a month.
+	CreatedAt string `json:"created_at"`
+	// From and To bound the period [From, To) in the requested time zone.
+	From  time.Time   `json:"from"`
+	To    time.Time   `json:"to"`
+	Cards []OrderItem `json:"cards"`
+	// Orders are the individual orders of the period whose cards are merged into Cards.
+	Orders []Order `json:"orders"`
+}
+
+// orderGrouping splits orders into calendar periods of one granularity in one time zone.
+type orderGrouping struct {
+	Location    *time.Location
+	Granularity string
+}
+
+func parseOrderGrouping(values url.Values) (orderGrouping, error) {
+	grouping := orderGrouping{Location: time.UTC, Granularity: groupByDay}
+
+	if name := values.Get("tz"); name != "" {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			return orderGrouping{}, invalidField("tz", fmt.Sprintf("unknown time zone %q", name))
+		}
+		grouping.Location = location
+	}
+
+	if granularity := values.Get("granularity"); granularity != "" {
+		if !contains(orderGranularities, granularity) {
+			return orderGrouping{}, invalidField("granularity", fmt.Sprintf("granularity must be one of %v", orderGranularities))
+		}
+		grouping.Granularity = granularity
+	}
+
+	return grouping, nil
+}
+
+// period returns the start and the end of the period containing t. Periods are built from
+// calendar dates rather than fixed durations, so days across DST changes stay whole.
+func (g orderGrouping) period(t time.Time) (time.Time, time.Time) {
+	year, month, day := t.In(g.Location).Date()
+
+	switch g.Granularity {
+	case groupByWeek:
+		// Weeks start on Monday.
+		day -= (int(time.Date(year, month, day, 0, 0, 0, 0, g.Location).Weekday()) + 6) % 7
+		return time.Date(year, month, day, 0, 0, 0, 0, g.Location), time.Date(year, month, day+7, 0, 0, 0, 0, g.Location)
+	case groupByMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, g.Location), time.Date(year, month+1, 1, 0, 0, 0, 0, g.Location)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, g.Location), time.Date(year, month, day+1, 0, 0, 0, 0, g.Location)
+	}
+}
+
+// newerOrder is the order of order listings: newest first, then by id.
+func newerOrder(a, b Order) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+
+	return a.ID < b.ID
+}
+
+func (g orderGrouping) label(start time.Time) string {
+	if g.Granularity == groupByMonth {
+		return start.Format("01.2006")
+	}
+
+	return start.Format("02.01.2006")
+}
+
+// group returns the orders grouped by period, newest period first. Within a period orders go
+// newest first as well; orders created at the same instant are ordered by id so that the
+// response does not depend on the order the store returned them in.
+func (g orderGrouping) group(orders []Order) []orderResponse {
+	orders = append([]Order(nil), orders...)
+	sort.SliceStable(orders, func(i, j int) bool {
+		return newerOrder(orders[i], orders[j])
+	})
+
+	response := []orderResponse{}
+	for _, order := range orders {
+		from, to := g.period(order.CreatedAt)
+
+		// Orders are sorted, so a new period can only start after the last one.
+		if last := len(response) - 1; last < 0 || !response[last].From.Equal(from) {
+			response = append(response, orderResponse{CreatedAt: g.label(from), From: from, To: to})
+		}
+
+		group := &response[len(response)-1]
+		group.Cards = append(group.Cards, order.Cards...)
+		group.Orders = append(group.Orders, order)
+	}
+
+	return response
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestOrderGroupingGroup(t *testing.T) {
+	type group struct {
+		Label  string
+		Orders []string
+	}
+
+	tests := []struct {
+		name        string
+		tz          string
+		granularity string
+		// orders maps order ids to their creation time.
+		orders map[string]string
+		want   []group
+	}{
+		{
+			name:   "days across a month boundary sort by date, not by label",
+			orders: map[string]string{"a": "2023-01-31T10:00:00Z", "b": "2023-02-01T10:00:00Z", "c": "2023-01-30T10:00:00Z"},
+			want: []group{
+				{"01.02.2023", []string{"b"}},
+				{"31.01.2023", []string{"a"}},
+				{"30.01.2023", []string{"c"}},
+			},
+		},
+		{
+			name:   "days across a year boundary",
+			orders: map[string]string{"a": "2022-12-31T23:59:59Z", "b": "2023-01-01T00:00:00Z"},
+			want: []group{
+				{"01.01.2023", []string{"b"}},
+				{"31.12.2022", []string{"a"}},
+			},
+		},
+		{
+			name:   "orders of one day go newest first, ties by id",
+			orders: map[string]string{"b": "2023-05-01T08:00:00Z", "a": "2023-05-01T08:00:00Z", "c": "2023-05-01T20:00:00Z"},
+			want: []group{
+				{"01.05.2023", []string{"c", "a", "b"}},
+			},
+		},
+		{
+			name:   "days in UTC by default",
+			orders: map[string]string{"a": "2023-03-10T22:30:00Z", "b": "2023-03-10T20:00:00Z"},
+			want: []group{
+				{"10.03.2023", []string{"a", "b"}},
+			},
+		},
+		{
+			name:   "days in the client time zone",
+			tz:     "Europe/Moscow",
+			orders: map[string]string{"a": "2023-03-10T22:30:00Z", "b": "2023-03-10T20:00:00Z"},
+			want: []group{
+				{"11.03.2023", []string{"a"}},
+				{"10.03.2023", []string{"b"}},
+			},
+		},
+		{
+			name: "days around the spring DST change",
+			tz:   "Europe/Berlin",
+			// 26.03.2023 is 23 hours long in Berlin: 00:00 CET to 00:00 CEST is 22:00Z to 22:00Z.
+			orders: map[string]string{"a": "2023-03-25T23:30:00Z", "b": "2023-03-26T21:30:00Z", "c": "2023-03-26T22:30:00Z"},
+			want: []group{
+				{"27.03.2023", []string{"c"}},
+				{"26.03.2023", []string{"b", "a"}},
+			},
+		},
+		{
+			name: "days around the autumn DST change",
+			tz:   "Europe/Berlin",
+			// 29.10.2023 is 25 hours long in Berlin: from 22:00Z on the 28th to 23:00Z on the 29th.
+			orders: map[string]string{"a": "2023-10-28T22:30:00Z", "b": "2023-10-29T22:30:00Z", "c": "2023-10-29T23:00:00Z"},
+			want: []group{
+				{"30.10.2023", []string{"c"}},
+				{"29.10.2023", []string{"b", "a"}},
+			},
+		},
+		{
+			name:        "weeks start on Monday",
+			granularity: groupByWeek,
+			orders:      map[string]string{"sun": "2023-01-01T12:00:00Z", "mon": "2023-01-02T00:00:00Z", "next-sun": "2023-01-08T23:59:59Z"},
+			want: []group{
+				{"02.01.2023", []string{"next-sun", "mon"}},
+				{"26.12.2022", []string{"sun"}},
+			},
+		},
+		{
+			name:        "weeks in the client time zone",
+			tz:          "America/New_York",
+			granularity: groupByWeek,
+			// Monday 03:00Z is still Sunday evening in New York.
+			orders: map[string]string{"a": "2023-01-09T03:00:00Z", "b": "2023-01-09T06:00:00Z"},
+			want: []group{
+				{"09.01.2023", []string{"b"}},
+				{"02.01.2023", []string{"a"}},
+			},
+		},
+		{
+			name:        "months",
+			granularity: groupByMonth,
+			orders:      map[string]string{"a": "2023-01-31T10:00:00Z", "b": "2023-02-01T10:00:00Z", "c": "2023-02-28T10:00:00Z", "d": "2022-12-01T00:00:00Z"},
+			want: []group{
+				{"02.2023", []string{"c", "b"}},
+				{"01.2023", []string{"a"}},
+				{"12.2022", []string{"d"}},
+			},
+		},
+		{
+			name:        "months in the client time zone",
+			tz:          "Europe/Moscow",
+			granularity: groupByMonth,
+			orders:      map[string]string{"a": "2023-01-31T21:30:00Z", "b": "2023-01-31T20:30:00Z"},
+			want: []group{
+				{"02.2023", []string{"a"}},
+				{"01.2023", []string{"b"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grouping, err := parseOrderGrouping(url.Values{"tz": {test.tz}, "granularity": {test.granularity}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var orders []Order
+			for id, createdAt := range test.orders {
+				orders = append(orders, Order{ID: id, CreatedAt: mustParseTime(t, createdAt)})
+			}
+
+			var got []group
+			for _, response := range grouping.group(orders) {
+				ids := make([]string, 0, len(response.Orders))
+				for _, order := range response.Orders {
+					ids = append(ids, order.ID)
+				}
+				got = append(got, group{response.CreatedAt, ids})
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("group() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrderGroupingPeriod(t *testing.T) {
+	tests := []struct {
+		name        string
+		tz          string
+		granularity string
+		at          string
+		from, to    string
+	}{
+		{"day", "", groupByDay, "2023-01-31T10:00:00Z", "2023-01-31T00:00:00Z", "2023-02-01T00:00:00Z"},
+		{"short DST day", "Europe/Berlin", groupByDay, "2023-03-26T12:00:00Z", "2023-03-26T00:00:00+01:00", "2023-03-27T00:00:00+02:00"},
+		{"long DST day", "Europe/Berlin", groupByDay, "2023-10-29T12:00:00Z", "2023-10-29T00:00:00+02:00", "2023-10-30T00:00:00+01:00"},
+		{"week across a year", "", groupByWeek, "2023-01-01T12:00:00Z", "2022-12-26T00:00:00Z", "2023-01-02T00:00:00Z"},
+		{"week across DST", "Europe/Berlin", groupByWeek, "2023-03-26T12:00:00Z", "2023-03-20T00:00:00+01:00", "2023-03-27T00:00:00+02:00"},
+		{"month", "", groupByMonth, "2023-02-14T12:00:00Z", "2023-02-01T00:00:00Z", "2023-03-01T00:00:00Z"},
+		{"december", "Europe/Moscow", groupByMonth, "2023-12-31T21:30:00Z", "2024-01-01T00:00:00+03:00", "2024-02-01T00:00:00+03:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grouping, err := parseOrderGrouping(url.Values{"tz": {test.tz}, "granularity": {test.granularity}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from, to := grouping.period(mustParseTime(t, test.at))
+			if want := mustParseTime(t, test.from); !from.Equal(want) {
+				t.Errorf("from = %v, want %v", from, want)
+			}
+			if want := mustParseTime(t, test.to); !to.Equal(want) {
+				t.Errorf("to = %v, want %v", to, want)
+			}
+		})
+	}
+}
+
+func TestParseOrderGrouping(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   url.Values
+		field   string
+		want    orderGrouping
+		wantErr bool
+	}{
+		{name: "defaults", query: url.Values{}, want: orderGrouping{Location: time.UTC, Granularity: groupByDay}},
+		{name: "unknown time zone", query: url.Values{"tz": {"Mars/Olympus"}}, field: "tz", wantErr: true},
+		{name: "unknown granularity", query: url.Values{"granularity": {"year"}}, field: "granularity", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseOrderGrouping(test.query)
+			if test.wantErr {
+				field, ok := err.(FieldError)
+				if !ok || field.Field != test.field {
+					t.Fatalf("err = %v, want a FieldError for %s", err, test.field)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
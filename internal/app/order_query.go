@@ -0,0 +1,161 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// OrderQuery selects a page of one user's orders, newest first.
+type OrderQuery struct {
+	// From and To bound CreatedAt to [From, To); either may be nil.
+	From     *time.Time
+	To       *time.Time
+	Statuses []string
+	MinTotal *float64
+	MaxTotal *float64
+	// CardID keeps only the orders that contain the card.
+	CardID string
+	Limit  int
+	Offset int
+}
+
+type OrderPage struct {
+	Orders []Order
+	// Total is the number of orders matching the filters, regardless of Limit and Offset.
+	Total int64
+}
+
+// parseOrderQuery reads the order filters. Dates without a time are taken in location, and a
+// date in to includes that whole day.
+func parseOrderQuery(values url.Values, location *time.Location) (OrderQuery, error) {
+	var (
+		query OrderQuery
+		err   error
+	)
+
+	if query.From, err = parseOptionalTime(values, "from", location, false); err != nil {
+		return OrderQuery{}, err
+	}
+
+	if query.To, err = parseOptionalTime(values, "to", location, true); err != nil {
+		return OrderQuery{}, err
+	}
+
+	if query.From != nil && query.To != nil && query.From.After(*query.To) {
+		return OrderQuery{}, invalidField("from", "from must not be after to")
+	}
+
+	for _, status := range strings.Split(values.Get("status"), ",") {
+		if status = strings.TrimSpace(status); status == "" {
+			continue
+		}
+
+		if !contains(orderStatuses, status) {
+			return OrderQuery{}, invalidField("status", fmt.Sprintf("unknown order status %q", status))
+		}
+		query.Statuses = append(query.Statuses, status)
+	}
+
+	if query.MinTotal, err = parseOptionalFloat(values, "min_total"); err != nil {
+		return OrderQuery{}, err
+	}
+
+	if query.MaxTotal, err = parseOptionalFloat(values, "max_total"); err != nil {
+		return OrderQuery{}, err
+	}
+
+	if query.MinTotal != nil && query.MaxTotal != nil && *query.MinTotal > *query.MaxTotal {
+		return OrderQuery{}, invalidField("min_total", "min_total must not exceed max_total")
+	}
+
+	query.CardID = values.Get("card_id")
+
+	if query.Limit, err = parseOptionalInt(values, "limit", 1, maxPageLimit); err != nil {
+		return OrderQuery{}, err
+	}
+
+	if query.Offset, err = parseOptionalInt(values, "offset", 0, -1); err != nil {
+		return OrderQuery{}, err
+	}
+
+	return query, nil
+}
+
+// parseOptionalTime accepts RFC 3339 or a plain date. With endOfDay set a plain date
+// is moved to the start of the next day, so that it can serve as an exclusive bound.
+func parseOptionalTime(values url.Values, key string, location *time.Location, endOfDay bool) (*time.Time, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return nil, nil
+	}
+
+	if value, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &value, nil
+	}
+
+	value, err := time.ParseInLocation(dateLayout, raw, location)
+	if err != nil {
+		return nil, invalidField(key, key+" must be a date (YYYY-MM-DD) or an RFC 3339 time")
+	}
+
+	if endOfDay {
+		value = value.AddDate(0, 0, 1)
+	}
+
+	return &value, nil
+}
+
+func (q OrderQuery) matches(order Order) bool {
+	if q.From != nil && order.CreatedAt.Before(*q.From) {
+		return false
+	}
+
+	if q.To != nil && !order.CreatedAt.Before(*q.To) {
+		return false
+	}
+
+	if len(q.Statuses) > 0 && !contains(q.Statuses, order.Status) {
+		return false
+	}
+
+	if q.MinTotal != nil && order.Total < *q.MinTotal {
+		return false
+	}
+
+	if q.MaxTotal != nil && order.Total > *q.MaxTotal {
+		return false
+	}
+
+	if q.CardID != "" {
+		for _, item := range order.Cards {
+			if item.ID == q.CardID {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// paginate cuts a page out of orders that already match the filters and are sorted.
+func (q OrderQuery) paginate(orders []Order) OrderPage {
+	page := OrderPage{Total: int64(len(orders))}
+
+	if q.Offset >= len(orders) {
+		orders = nil
+	} else {
+		orders = orders[q.Offset:]
+	}
+
+	if q.Limit > 0 && len(orders) > q.Limit {
+		orders = orders[:q.Limit]
+	}
+
+	page.Orders = append(make([]Order, 0, len(orders)), orders...)
+	return page
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	roleCustomer = "customer"
+	roleAdmin    = "admin"
+)
+
+var roles = []string{roleCustomer, roleAdmin}
+
+// effectiveRole treats users stored before roles existed as customers.
+func effectiveRole(role string) string {
+	if role == "" {
+		return roleCustomer
+	}
+
+	return role
+}
+
+// RequireRole lets through authenticated callers that have one of the given roles.
+// Anonymous callers get 401, authenticated callers with another role get 403.
+func RequireRole(allowed ...string) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return RequireAuth(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			principal, _ := principalFromContext(request.Context())
+			if !contains(allowed, effectiveRole(principal.Role)) {
+				writeProblem(writer, http.StatusForbidden, codeForbidden, "requires role "+strings.Join(allowed, " or "))
+				return
+			}
+
+			handler.ServeHTTP(writer, request)
+		}))
+	}
+}
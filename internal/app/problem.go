@@ -0,0 +1,131 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	requestIDHeader    = "X-Request-ID"
+)
+
+// Machine-readable error codes, returned in the "code" member of a Problem.
+const (
+	codeInvalidQuery         = "invalid_query"
+	codeInvalidBody          = "invalid_body"
+	codeValidationFailed     = "validation_failed"
+	codeInvalidHeader        = "invalid_header"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codeNotAcceptable        = "not_acceptable"
+	codePayloadTooLarge      = "payload_too_large"
+	codeUnauthorized         = "unauthorized"
+	codeInvalidToken         = "invalid_token"
+	codeInvalidCredentials   = "invalid_credentials"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeAlreadyExists        = "already_exists"
+	codeEmptyCart            = "empty_cart"
+	codeQuantityLimit        = "quantity_limit"
+	codeUnknownCard          = "unknown_card"
+	codeInvalidTransition    = "invalid_transition"
+	codeImageInUse           = "image_in_use"
+	codeUnsupportedImage     = "unsupported_image"
+	codeUnprocessableImage   = "unprocessable_image"
+	codeInternal             = "internal_error"
+)
+
+// Problem is an RFC 7807 error response. Type is always "about:blank", so Title is the HTTP
+// status text; Code tells errors with the same status apart.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes what is wrong with a single query parameter or body field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// writeProblem is the single place that renders error responses.
+func writeProblem(writer http.ResponseWriter, status int, code, detail string, fields ...FieldError) {
+	response, err := json.Marshal(Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: writer.Header().Get(requestIDHeader),
+		Errors:    fields,
+	})
+	if err != nil {
+		log.Println(err)
+		writer.WriteHeader(status)
+		return
+	}
+
+	writer.Header().Set("Content-Type", problemContentType)
+	writer.WriteHeader(status)
+	if _, err = writer.Write(response); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeInternalError logs err with the request ID and answers 500 without exposing err.
+func writeInternalError(writer http.ResponseWriter, err error) {
+	log.Printf("request %s: %v", writer.Header().Get(requestIDHeader), err)
+	writeProblem(writer, http.StatusInternalServerError, codeInternal, "")
+}
+
+// writeQueryError answers 400 for an invalid query string, pointing at the parameter when
+// err is a FieldError.
+func writeQueryError(writer http.ResponseWriter, err error) {
+	var field FieldError
+	if errors.As(err, &field) {
+		writeProblem(writer, http.StatusBadRequest, codeInvalidQuery, err.Error(), field)
+		return
+	}
+
+	writeProblem(writer, http.StatusBadRequest, codeInvalidQuery, err.Error())
+}
+
+// writeValidationError answers 422 for a well-formed body with invalid fields.
+func writeValidationError(writer http.ResponseWriter, fields ...FieldError) {
+	writeProblem(writer, http.StatusUnprocessableEntity, codeValidationFailed, "request body has invalid fields", fields...)
+}
+
+func invalidField(field, message string) FieldError {
+	return FieldError{Field: field, Message: message}
+}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID tags every response with an X-Request-ID header, reusing the one sent by the client
+// or a proxy when it looks sane. Error responses and logs refer to it.
+func RequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+
+		writer.Header().Set(requestIDHeader, id)
+		handler.ServeHTTP(writer, request)
+	})
+}
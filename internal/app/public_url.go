@@ -0,0 +1,205 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const storagePath = "/api/storage/"
+
+type baseURLKey struct{}
+
+// baseURLFromContext returns the public URL of the API the request was made to, without a trailing slash.
+func baseURLFromContext(ctx context.Context) string {
+	base, _ := ctx.Value(baseURLKey{}).(string)
+	return base
+}
+
+// trustedProxies are the addresses allowed to say, in X-Forwarded-* headers, how the API was reached.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies accepts IP addresses and CIDR ranges.
+func parseTrustedProxies(items []string) (trustedProxies, error) {
+	proxies := make(trustedProxies, 0, len(items))
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or a CIDR range", item)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or a CIDR range", item)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+func (p trustedProxies) trusts(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PublicBaseURL works out the public URL of the API for links in responses. A configured
+// baseURL always wins. Otherwise the URL is the one the request was sent to, with the scheme
+// and host taken from X-Forwarded-Proto and X-Forwarded-Host when the request comes from one
+// of the trusted proxies.
+func PublicBaseURL(baseURL string, proxies trustedProxies) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			base := baseURL
+			if base == "" {
+				base = requestBaseURL(request, proxies)
+			}
+
+			handler.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), baseURLKey{}, base)))
+		})
+	}
+}
+
+func requestBaseURL(request *http.Request, proxies trustedProxies) string {
+	scheme, host := "http", request.Host
+	if request.TLS != nil {
+		scheme = "https"
+	}
+
+	if proxies.trusts(request.RemoteAddr) {
+		if proto := strings.ToLower(firstHeaderValue(request, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+
+		if forwarded := firstHeaderValue(request, "X-Forwarded-Host"); validHost(forwarded) {
+			host = forwarded
+		}
+	}
+
+	return scheme + "://" + host
+}
+
+// firstHeaderValue returns the first of the comma-separated values of a header, which is
+// the one added by the proxy closest to the client.
+func firstHeaderValue(request *http.Request, name string) string {
+	value, _, _ := strings.Cut(request.Header.Get(name), ",")
+	return strings.TrimSpace(value)
+}
+
+func validHost(host string) bool {
+	if host == "" {
+		return false
+	}
+
+	parsed, err := url.Parse("http://" + host)
+	return err == nil && parsed.Host == host && parsed.User == nil
+}
+
+// imageKey turns the image of a card into the form it is stored in. Links to this API's
+// storage, whatever host they were built for, are reduced to the stored image name, so that
+// they can be resolved against the public URL of every later request. Other values are kept.
+func imageKey(img string) string {
+	parsed, err := url.Parse(img)
+	if err != nil || !strings.HasPrefix(parsed.Path, storagePath) || (parsed.Scheme != "" && parsed.Host == "") {
+		return img
+	}
+
+	name := strings.TrimPrefix(parsed.Path, storagePath)
+	if !validImageName(name) {
+		return img
+	}
+
+	return name
+}
+
+// imageLink is the reverse of imageKey: a stored image name becomes a link to the storage,
+// anything else (an absolute URL elsewhere, an empty value) is returned unchanged.
+func imageLink(baseURL, img string) string {
+	key := imageKey(img)
+	if !validImageName(key) || strings.Contains(key, ":") {
+		return img
+	}
+
+	return imageURL(baseURL, key)
+}
+
+func imageURL(baseURL, name string) string {
+	return baseURL + storagePath + url.PathEscape(name)
+}
+
+// withImageURLs is implemented by everything that carries cards to a client.
+type withImageURLs[T any] interface {
+	withImageURLs(baseURL string) T
+}
+
+// resolveImages returns a copy of items with the images of their cards turned into links.
+func resolveImages[T withImageURLs[T]](baseURL string, items []T) []T {
+	if items == nil {
+		return nil
+	}
+
+	resolved := make([]T, len(items))
+	for i, item := range items {
+		resolved[i] = item.withImageURLs(baseURL)
+	}
+
+	return resolved
+}
+
+func (c Card) withImageURLs(baseURL string) Card {
+	c.Img = imageLink(baseURL, c.Img)
+	return c
+}
+
+func (h SearchHit) withImageURLs(baseURL string) SearchHit {
+	h.Card = h.Card.withImageURLs(baseURL)
+	return h
+}
+
+func (i CartItem) withImageURLs(baseURL string) CartItem {
+	i.Card = i.Card.withImageURLs(baseURL)
+	return i
+}
+
+func (i OrderItem) withImageURLs(baseURL string) OrderItem {
+	i.Card = i.Card.withImageURLs(baseURL)
+	return i
+}
+
+func (o Order) withImageURLs(baseURL string) Order {
+	o.Cards = resolveImages(baseURL, o.Cards)
+	return o
+}
+
+func (r orderResponse) withImageURLs(baseURL string) orderResponse {
+	r.Cards = resolveImages(baseURL, r.Cards)
+	r.Orders = resolveImages(baseURL, r.Orders)
+	return r
+}
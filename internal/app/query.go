@@ -0,0 +1,293 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const maxPageLimit = 1000
+
+// cardSortFields maps the names accepted in ?sort= to Card fields.
+var cardSortFields = map[string]func(card Card) interface{}{
+	"id":    func(card Card) interface{} { return card.ID },
+	"name":  func(card Card) interface{} { return card.Name },
+	"price": func(card Card) interface{} { return card.Price },
+}
+
+type sortField struct {
+	Name string
+	Desc bool
+}
+
+// CardQuery selects a page of cards. Sort always ends with "id" so that the order is total
+// and a cursor identifies a single position.
+type CardQuery struct {
+	Name     string
+	MinPrice *float64
+	MaxPrice *float64
+	Sort     []sortField
+	Limit    int
+	Offset   int
+	After    *pageCursor
+}
+
+type CardPage struct {
+	Cards []Card
+	// Total is the number of cards matching the filters, regardless of Limit, Offset and After.
+	Total int64
+	// NextCursor continues the listing after the last returned card; empty on the last page.
+	NextCursor string
+}
+
+// pageCursor is the position after which a page starts: the sort key of the last card seen.
+type pageCursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+func parseCardQuery(values url.Values) (CardQuery, error) {
+	var (
+		query CardQuery
+		err   error
+	)
+
+	query.Name = values.Get("name")
+
+	if query.MinPrice, err = parseOptionalFloat(values, "min_price"); err != nil {
+		return CardQuery{}, err
+	}
+
+	if query.MaxPrice, err = parseOptionalFloat(values, "max_price"); err != nil {
+		return CardQuery{}, err
+	}
+
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return CardQuery{}, invalidField("min_price", "min_price must not exceed max_price")
+	}
+
+	if query.Sort, err = parseSort(values.Get("sort")); err != nil {
+		return CardQuery{}, err
+	}
+
+	if query.Limit, err = parseOptionalInt(values, "limit", 1, maxPageLimit); err != nil {
+		return CardQuery{}, err
+	}
+
+	if query.Offset, err = parseOptionalInt(values, "offset", 0, -1); err != nil {
+		return CardQuery{}, err
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		if query.Offset != 0 {
+			return CardQuery{}, invalidField("cursor", "cursor and offset are mutually exclusive")
+		}
+
+		if query.After, err = decodeCursor(raw, query.Sort); err != nil {
+			return CardQuery{}, err
+		}
+	}
+
+	return query, nil
+}
+
+// parseSort parses a comma-separated list like "price,-name" and appends the "id" tie-breaker.
+func parseSort(raw string) ([]sortField, error) {
+	var fields []sortField
+	hasID := false
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		field := sortField{Name: strings.TrimPrefix(item, "-"), Desc: strings.HasPrefix(item, "-")}
+		if _, ok := cardSortFields[field.Name]; !ok {
+			return nil, invalidField("sort", fmt.Sprintf("unknown sort field %q", field.Name))
+		}
+
+		hasID = hasID || field.Name == "id"
+		fields = append(fields, field)
+	}
+
+	if !hasID {
+		fields = append(fields, sortField{Name: "id"})
+	}
+
+	return fields, nil
+}
+
+func formatSort(fields []sortField) string {
+	items := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field.Desc {
+			items = append(items, "-"+field.Name)
+		} else {
+			items = append(items, field.Name)
+		}
+	}
+
+	return strings.Join(items, ",")
+}
+
+func encodeCursor(card Card, fields []sortField) string {
+	cursor := pageCursor{Sort: formatSort(fields), Values: CardQuery{Sort: fields}.sortKey(card)}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string, fields []sortField) (*pageCursor, error) {
+	errInvalid := invalidField("cursor", "invalid cursor")
+
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalid
+	}
+
+	var cursor pageCursor
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return nil, errInvalid
+	}
+
+	if cursor.Sort != formatSort(fields) {
+		return nil, invalidField("cursor", "cursor was issued for a different sort order")
+	}
+
+	if len(cursor.Values) != len(fields) {
+		return nil, errInvalid
+	}
+
+	for i, field := range fields {
+		want := cardSortFields[field.Name](Card{})
+		if fmt.Sprintf("%T", want) != fmt.Sprintf("%T", cursor.Values[i]) {
+			return nil, errInvalid
+		}
+	}
+
+	return &cursor, nil
+}
+
+// compareSortValues compares two values of the same sort field.
+func compareSortValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+
+	return 0
+}
+
+func parseOptionalFloat(values url.Values, key string) (*float64, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, invalidField(key, key+" must be a number")
+	}
+
+	return &value, nil
+}
+
+// parseOptionalInt returns 0 when key is absent. A negative max means no upper bound.
+func parseOptionalInt(values url.Values, key string, min, max int) (int, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || (max >= 0 && value > max) {
+		if max >= 0 {
+			return 0, invalidField(key, fmt.Sprintf("%s must be an integer between %d and %d", key, min, max))
+		}
+		return 0, invalidField(key, fmt.Sprintf("%s must be an integer not less than %d", key, min))
+	}
+
+	return value, nil
+}
+
+func (q CardQuery) matches(card Card) bool {
+	if q.Name != "" && !strings.Contains(strings.ToLower(card.Name), strings.ToLower(q.Name)) {
+		return false
+	}
+
+	if q.MinPrice != nil && card.Price < *q.MinPrice {
+		return false
+	}
+
+	if q.MaxPrice != nil && card.Price > *q.MaxPrice {
+		return false
+	}
+
+	return true
+}
+
+// compare orders card relative to the sort key values, honoring each field's direction.
+func (q CardQuery) compare(card Card, values []interface{}) int {
+	for i, field := range q.Sort {
+		result := compareSortValues(cardSortFields[field.Name](card), values[i])
+		if field.Desc {
+			result = -result
+		}
+
+		if result != 0 {
+			return result
+		}
+	}
+
+	return 0
+}
+
+func (q CardQuery) sortKey(card Card) []interface{} {
+	values := make([]interface{}, 0, len(q.Sort))
+	for _, field := range q.Sort {
+		values = append(values, cardSortFields[field.Name](card))
+	}
+
+	return values
+}
+
+// paginate cuts a page out of cards that already match the filters and are sorted by q.Sort.
+func (q CardQuery) paginate(cards []Card) CardPage {
+	page := CardPage{Total: int64(len(cards))}
+
+	if q.After != nil {
+		start := len(cards)
+		for i, card := range cards {
+			if q.compare(card, q.After.Values) > 0 {
+				start = i
+				break
+			}
+		}
+		cards = cards[start:]
+	}
+
+	if q.Offset >= len(cards) {
+		cards = nil
+	} else {
+		cards = cards[q.Offset:]
+	}
+
+	if q.Limit > 0 && len(cards) > q.Limit {
+		cards = cards[:q.Limit]
+		page.NextCursor = encodeCursor(cards[len(cards)-1], q.Sort)
+	}
+
+	page.Cards = append(make([]Card, 0, len(cards)), cards...)
+	return page
+}
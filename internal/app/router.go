@@ -1,37 +1,85 @@
 package app
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"go.mongodb.org/mongo-driver/mongo"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
-func newRouter(db *mongo.Database) http.Handler {
+func newRouter(store Store, blobs BlobStore, variants variantCache, tokens *tokenIssuer, proxies trustedProxies, cors corsPolicy, cfg Config) http.Handler {
 	router := chi.NewRouter()
 
-	router.Use(Cors)
+	router.Use(RequestID)
+	router.Use(Cors(cors))
+	router.Use(PublicBaseURL(cfg.BaseURL, proxies))
+	router.Use(Authenticate(tokens))
+
+	router.NotFound(func(writer http.ResponseWriter, request *http.Request) {
+		writeProblem(writer, http.StatusNotFound, codeNotFound, "no route for "+request.URL.Path)
+	})
+	router.MethodNotAllowed(func(writer http.ResponseWriter, request *http.Request) {
+		writeProblem(writer, http.StatusMethodNotAllowed, codeMethodNotAllowed, request.Method+" is not allowed here")
+	})
 
 	router.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL("doc.json")))
 
-	router.Get("/api/storage/{id}", GetImage())
-	router.Post("/api/storage", UploadImage())
+	router.With(RequireRole(roleAdmin)).Get("/debug/vars", expvar.Handler().ServeHTTP)
+
+	router.Get("/api/storage/{id}", GetImage(blobs, variants))
+
+	// Everything below answers in JSON or MessagePack. Accept is checked before the handlers
+	// run, so that a request whose answer cannot be encoded changes nothing.
+	router.Group(func(router chi.Router) {
+		router.Use(Negotiate(responseMediaTypes...))
+
+		router.Group(func(router chi.Router) {
+			router.Use(RequireRole(roleAdmin))
+
+			router.Get("/api/storage", AllImages(store.Images))
+			router.Post("/api/storage", UploadImage(blobs, store.Images, int64(cfg.MaxUploadSize)))
+			router.Get("/api/storage/{id}/meta", GetImageMeta(store.Images, blobs))
+			router.Delete("/api/storage/{id}", DeleteImage(store.Images, blobs, store.Cards, variants))
+		})
+
+		router.Post("/api/auth/register", Register(store.Users, tokens, cfg.AdminEmails))
+		router.Post("/api/auth/login", Login(store.Users, tokens))
+		router.Post("/api/auth/refresh", Refresh(store.Users, tokens))
+		router.With(RequireAuth).Get("/api/auth/me", Me(store.Users))
+		router.With(RequireRole(roleAdmin)).Put("/api/users/{id}/role", SetUserRole(store.Users))
+
+		router.Get("/api/cards", AllCards(store.Cards))
+		router.With(RequireRole(roleAdmin)).Post("/api/cards", PostCard(store.Cards))
+		router.Get("/api/cards/search", SearchCards(store.Cards))
+		router.Get("/api/cards/{id}", GetCard(store.Cards))
+		router.With(RequireRole(roleAdmin)).Put("/api/cards/{id}", PutCard(store.Cards))
+		router.With(RequireRole(roleAdmin)).Patch("/api/cards/{id}", PatchCard(store.Cards))
+		router.With(RequireRole(roleAdmin)).Delete("/api/cards/{id}", DeleteCard(store.Cards))
+
+		router.Group(func(router chi.Router) {
+			router.Use(Identify(store.Users))
 
-	router.Get("/api/cards", AllCards(db))
-	router.Post("/api/cards", PostCard(db))
+			router.Get("/api/cards/favorite", GetFavorites(store.Favorites))
+			router.Post("/api/cards/favorite", PostFavorite(store.Cards, store.Favorites))
+			router.Delete("/api/cards/favorite/{id}", DeleteFavorite(store.Favorites))
 
-	router.Get("/api/cards/favorite", GetFavorites(db))
-	router.Post("/api/cards/favorite", PostFavorite(db))
-	router.Delete("/api/cards/favorite/{id}", DeleteFavorite(db))
+			router.Get("/api/cards/cart", GetCart(store.Cart))
+			router.Post("/api/cards/cart", PostCart(store.Cards, store.Cart))
+			router.Delete("/api/cards/cart", ClearCart(store.Cart))
+			router.Post("/api/cards/cart/checkout", Checkout(store.Orders))
+			router.Patch("/api/cards/cart/{id}", PatchCart(store.Cart))
+			router.Delete("/api/cards/cart/{id}", DeleteCart(store.Cart))
 
-	router.Get("/api/cards/cart", GetCart(db))
-	router.Post("/api/cards/cart", PostCart(db))
-	router.Delete("/api/cards/cart/{id}", DeleteCart(db))
+			router.Get("/api/cards/order", GetOrders(store.Orders))
+			router.Post("/api/cards/order", PostOrder(store.Cards, store.Orders))
 
-	router.Get("/api/cards/order", GetOrders(db))
-	router.Post("/api/cards/order", PostOrder(db))
+			router.Get("/api/orders/{id}", GetOrder(store.Orders))
+			router.Post("/api/orders/{id}/status", PostOrderStatus(store.Orders))
+			router.Post("/api/orders/{id}/cancel", CancelOrder(store.Orders))
+		})
+	})
 
 	return router
 }
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	highlightOpen  = "<mark>"
+	highlightClose = "</mark>"
+)
+
+// SearchHit is a card found by full-text search.
+type SearchHit struct {
+	Card
+	// Score is the relevance of the hit; higher is better.
+	Score float64 `json:"score"`
+	// Highlight is the HTML-escaped card name with matched words wrapped in <mark>.
+	Highlight string `json:"highlight"`
+	// Fuzzy is set when the hit was found by the typo-tolerant fallback.
+	Fuzzy bool `json:"fuzzy"`
+}
+
+// searchTerms splits text into lower-cased words.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// typoTolerance is the number of edits allowed for a word of the given length.
+func typoTolerance(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matchWord scores how well a word of a card name matches a query term:
+// 1 for the same word, 0.75 when one is a prefix of the other (covers most inflections),
+// and, only if fuzzy is set, less than 0.5 for words within typoTolerance edits.
+func matchWord(word, term string, fuzzy bool) float64 {
+	switch {
+	case word == term:
+		return 1
+	case len([]rune(term)) >= 3 && (strings.HasPrefix(word, term) || (strings.HasPrefix(term, word) && len([]rune(word)) >= 3)):
+		return 0.75
+	case fuzzy:
+		tolerance := typoTolerance(term)
+		if distance := levenshtein(word, term); distance <= tolerance && tolerance > 0 {
+			return 0.5 * (1 - float64(distance)/float64(tolerance+1))
+		}
+	}
+
+	return 0
+}
+
+// scoreName returns the average best match of every query term against the words of name.
+// A name scores zero unless every term matches some word.
+func scoreName(name string, terms []string, fuzzy bool) float64 {
+	words := searchTerms(name)
+
+	total := 0.0
+	for _, term := range terms {
+		best := 0.0
+		for _, word := range words {
+			if score := matchWord(word, term, fuzzy); score > best {
+				best = score
+			}
+		}
+
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+
+	return total / float64(len(terms))
+}
+
+// searchCards ranks cards against text in memory. Fuzzy matching is only tried when the
+// strict pass finds nothing, the same way the Mongo store falls back from its text index.
+func searchCards(cards []Card, text string, limit int) []SearchHit {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var hits []SearchHit
+	for _, fuzzy := range []bool{false, true} {
+		for _, card := range cards {
+			if score := scoreName(card.Name, terms, fuzzy); score > 0 {
+				hits = append(hits, SearchHit{Card: card, Score: score, Fuzzy: fuzzy})
+			}
+		}
+
+		if len(hits) > 0 {
+			break
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	for i := range hits {
+		hits[i].Highlight = highlight(hits[i].Name, terms, hits[i].Fuzzy)
+	}
+
+	return hits
+}
+
+// highlight HTML-escapes name and wraps every word matching one of terms in <mark>.
+func highlight(name string, terms []string, fuzzy bool) string {
+	var builder strings.Builder
+
+	runes := []rune(name)
+	for start := 0; start < len(runes); {
+		end := start
+		isWord := unicode.IsLetter(runes[start]) || unicode.IsDigit(runes[start])
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) == isWord {
+			end++
+		}
+
+		chunk := string(runes[start:end])
+		matched := false
+		if isWord {
+			for _, term := range terms {
+				if matchWord(strings.ToLower(chunk), term, fuzzy) > 0 {
+					matched = true
+					break
+				}
+			}
+		}
+
+		if matched {
+			builder.WriteString(highlightOpen + html.EscapeString(chunk) + highlightClose)
+		} else {
+			builder.WriteString(html.EscapeString(chunk))
+		}
+
+		start = end
+	}
+
+	return builder.String()
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, value := range rest {
+		if value < first {
+			first = value
+		}
+	}
+
+	return first
+}
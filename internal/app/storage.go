@@ -0,0 +1,273 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	uploadFormField = "file"
+	// multipartOverhead is allowed on top of the file itself for boundaries and part headers.
+	multipartOverhead = 64 << 10
+	tempFilePattern   = ".upload-*"
+	spoolFilePattern  = "mock-api-upload-*"
+)
+
+var (
+	errUploadTooLarge   = errors.New("upload is too large")
+	errNoUploadFile     = errors.New("no file in the upload")
+	errUnsupportedImage = errors.New("file is not a supported image")
+
+	// contentAddressedName matches the names UploadImage gives files: the SHA-256 of the content
+	// and an optional extension.
+	contentAddressedName = regexp.MustCompile(`^([0-9a-f]{64})(\.[a-z0-9]{1,5})?$`)
+)
+
+type imageResponse struct {
+	ID string `json:"id"`
+	// ContentType is the type detected from the content of the file.
+	ContentType string `json:"content_type"`
+	URL         string `json:"url"`
+}
+
+// UploadImage godoc
+// @Summary      Загрузить картинку
+// @Description  Файл сохраняется под именем из SHA-256 содержимого, поэтому повторная загрузка того же файла возвращает тот же id.
+// @Description  Тип определяется по содержимому файла; принимаются JPEG, PNG, WebP, GIF и AVIF.
+// @Tags         storage
+// @Accept       multipart/form-data
+// @Produce      json,application/msgpack
+// @param        file formData file true "file"
+// @Success      201 {object} imageResponse
+// @Security     BearerAuth
+// @Failure      400 {object} Problem
+// @Failure      401 {object} Problem
+// @Failure      403 {object} Problem
+// @Failure      413 {object} Problem "файл больше max_upload_size"
+// @Failure      415 {object} Problem "не multipart/form-data или файл не является картинкой допустимого типа"
+// @Router       /api/storage [post]
+func UploadImage(blobs BlobStore, images ImageRepository, maxSize int64) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request.Body = http.MaxBytesReader(writer, request.Body, maxSize+multipartOverhead)
+
+		reader, err := request.MultipartReader()
+		if err != nil {
+			writeProblem(writer, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "body must be multipart/form-data")
+			return
+		}
+
+		image, err := receiveUpload(request.Context(), reader, blobs, maxSize)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, errUploadTooLarge), errors.As(err, &maxBytesErr):
+				writeProblem(writer, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+					fmt.Sprintf("the image must not exceed %d bytes", maxSize))
+			case errors.Is(err, errUnsupportedImage):
+				writeProblem(writer, http.StatusUnsupportedMediaType, codeUnsupportedImage,
+					"only JPEG, PNG, GIF, WebP and AVIF images are accepted")
+			case errors.Is(err, errNoUploadFile):
+				writeValidationError(writer, invalidField(uploadFormField, "a file is required"))
+			default:
+				writeInternalError(writer, err)
+			}
+			return
+		}
+
+		if principal, ok := principalFromContext(request.Context()); ok {
+			image.UploadedBy = principal.UserID
+		}
+
+		// The same content uploaded again keeps the metadata of the first upload.
+		if err = images.Create(request.Context(), image); err != nil && !errors.Is(err, errAlreadyExists) {
+			writeInternalError(writer, err)
+			return
+		}
+
+		writeResponse(writer, request, http.StatusCreated, imageResponse{
+			ID:          image.ID,
+			ContentType: image.ContentType,
+			URL:         imageURL(baseURLFromContext(request.Context()), image.ID),
+		})
+	}
+}
+
+// receiveUpload finds the file part of the form, checks that it is an allowed image and stores it
+// in blobs under the SHA-256 of its content and the extension of the detected type, so that the
+// same file uploaded twice ends up in one place.
+func receiveUpload(ctx context.Context, reader *multipart.Reader, blobs BlobStore, maxSize int64) (Image, error) {
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return Image{}, errNoUploadFile
+		}
+		if err != nil {
+			return Image{}, err
+		}
+
+		if part.FormName() != uploadFormField || part.FileName() == "" {
+			continue
+		}
+
+		detected, content, err := sniffImage(part)
+		if err != nil {
+			return Image{}, err
+		}
+
+		spool, hash, size, err := spoolUpload(content, maxSize)
+		if err != nil {
+			return Image{}, err
+		}
+		defer removeSpool(spool)
+
+		image := Image{ID: hash + detected.Extension, Size: size, ContentType: detected.MIME, UploadedAt: time.Now()}
+		return image, blobs.Put(ctx, image.ID, image.ContentType, spool, size)
+	}
+}
+
+// spoolUpload copies source to a temporary file, hashing it on the way, since the name of
+// a blob is only known once all of it has been read. The file is returned rewound.
+func spoolUpload(source io.Reader, maxSize int64) (spool *os.File, hash string, size int64, err error) {
+	spool, err = os.CreateTemp("", spoolFilePattern)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer func() {
+		if err != nil {
+			removeSpool(spool)
+		}
+	}()
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(spool, hasher), io.LimitReader(source, maxSize+1))
+	if err == nil && size > maxSize {
+		err = errUploadTooLarge
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return spool, hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+func removeSpool(spool *os.File) {
+	if err := spool.Close(); err != nil {
+		log.Println(err)
+	}
+
+	if err := os.Remove(spool.Name()); err != nil {
+		log.Println(err)
+	}
+}
+
+// validImageName accepts plain file names only, so that a name cannot point outside the storage
+// directory or at the temporary files of uploads in progress.
+func validImageName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`+"\x00") && filepath.Base(name) == name
+}
+
+// GetImage godoc
+// @Summary      Получить картинку
+// @Description  Поддерживаются запросы диапазонов (Range) и условные запросы (If-None-Match, If-Modified-Since).
+// @Description  Параметры w, h, fit и format возвращают уменьшенную или перекодированную копию, она кешируется на диске.
+// @Description  fit=contain вписывает картинку в w×h, cover заполняет w×h с обрезкой по центру, fill растягивает.
+// @Tags         storage
+// @Produce      image/jpeg,image/png,image/webp,image/gif,image/avif
+// @param        id     path  string true  "id"
+// @param        w      query int    false "ширина, до 2048"
+// @param        h      query int    false "высота, до 2048"
+// @param        fit    query string false "способ вписывания" Enums(contain, cover, fill) default(contain)
+// @param        format query string false "формат результата (по умолчанию — как у исходной картинки)" Enums(jpeg, png, gif, webp)
+// @Success      200
+// @Success      206
+// @Success      304
+// @Failure      400 {object} Problem
+// @Failure      404 {object} Problem
+// @Failure      415 {object} Problem "исходный формат нельзя перекодировать без format"
+// @Failure      422 {object} Problem "картинку не удалось декодировать"
+// @Router       /api/storage/{id} [get]
+func GetImage(blobs BlobStore, variants variantCache) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		name := chi.URLParam(request, "id")
+		if !validImageName(name) {
+			writeProblem(writer, http.StatusNotFound, codeNotFound, "")
+			return
+		}
+
+		variant, transform, err := parseImageVariant(request.URL.Query())
+		if err != nil {
+			writeQueryError(writer, err)
+			return
+		}
+
+		blob, err := blobs.Open(request.Context(), name)
+		if err != nil {
+			writeStoreError(writer, err)
+			return
+		}
+		defer func() {
+			if err := blob.Close(); err != nil {
+				log.Println(err)
+			}
+		}()
+
+		if transform {
+			serveVariant(writer, request, variants, blob, variant)
+			return
+		}
+
+		if match := contentAddressedName.FindStringSubmatch(name); match != nil {
+			// The content of a content-addressed file never changes.
+			writer.Header().Set("ETag", `"`+match[1]+`"`)
+			writer.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		writer.Header().Set("Content-Type", blob.ContentType)
+		writer.Header().Set("X-Content-Type-Options", "nosniff")
+
+		http.ServeContent(writer, request, name, blob.ModTime, blob)
+	}
+}
+
+// storedImageType returns the type of a local file. Files saved by UploadImage carry the
+// detected type in their extension; files put into the storage directory by other means
+// are sniffed, and anything that is not an allowed image is served as opaque bytes.
+func storedImageType(name string, file io.ReadSeeker) (string, error) {
+	if contentAddressedName.MatchString(name) {
+		if known, ok := imageTypeByExtension(filepath.Ext(name)); ok {
+			return known.MIME, nil
+		}
+	}
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if detected, ok := detectImageType(head[:n]); ok {
+		return detected.MIME, nil
+	}
+
+	return "application/octet-stream", nil
+}
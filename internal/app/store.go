@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	storeMongo  = "mongo"
+	storeMemory = "memory"
+)
+
+var (
+	errNotFound      = errors.New("not found")
+	errAlreadyExists = errors.New("already exists")
+	errQuantityLimit = errors.New("quantity limit exceeded")
+	errEmptyCart     = errors.New("cart is empty")
+)
+
+// CardRepository stores the catalogue. Favorites and cart keep their own copies of a card,
+// so Update and Delete propagate to them as well.
+type CardRepository interface {
+	Find(ctx context.Context, query CardQuery) (CardPage, error)
+	Get(ctx context.Context, id string) (Card, error)
+	Search(ctx context.Context, text string, limit int) ([]SearchHit, error)
+	Create(ctx context.Context, card Card) error
+	Update(ctx context.Context, card Card) error
+	Delete(ctx context.Context, id string) error
+	// UsesImage reports whether some card shows the image with the id.
+	UsesImage(ctx context.Context, imageID string) (bool, error)
+}
+
+// FavoriteRepository keeps a separate list of favorite cards for every user.
+type FavoriteRepository interface {
+	All(ctx context.Context, userID string) ([]Card, error)
+	Add(ctx context.Context, userID string, card Card) error
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// CartRepository keeps a separate cart for every user. A card appears in a cart at most once,
+// as a line with a quantity; a line whose quantity drops to zero is removed.
+type CartRepository interface {
+	All(ctx context.Context, userID string) ([]CartItem, error)
+	// Add puts quantity units of card into the cart, increasing the quantity of an existing line.
+	Add(ctx context.Context, userID string, card Card, quantity int) (CartItem, error)
+	// Adjust changes the quantity of a line by delta, which may be negative.
+	Adjust(ctx context.Context, userID, id string, delta int) error
+	SetQuantity(ctx context.Context, userID, id string, quantity int) error
+	Delete(ctx context.Context, userID, id string) error
+	Clear(ctx context.Context, userID string) error
+}
+
+type OrderRepository interface {
+	// Find returns the user's orders matching query, newest first.
+	Find(ctx context.Context, userID string, query OrderQuery) (OrderPage, error)
+	Get(ctx context.Context, id string) (Order, error)
+	Create(ctx context.Context, order Order) error
+	// SetStatus moves the order from status from to status to. It fails with
+	// errInvalidTransition if the order is no longer in status from.
+	SetStatus(ctx context.Context, id, from, to string, at time.Time) error
+	// Checkout atomically turns the user's cart into the order returned by build and empties
+	// the cart. It fails with errEmptyCart when there is nothing to order.
+	Checkout(ctx context.Context, userID string, build func(items []CartItem) Order) (Order, error)
+}
+
+// UserRepository stores registered users; emails are unique.
+type UserRepository interface {
+	Create(ctx context.Context, user User) error
+	Get(ctx context.Context, id string) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	SetRole(ctx context.Context, id, role string) error
+}
+
+// ImageRepository keeps the metadata of uploaded images.
+type ImageRepository interface {
+	Find(ctx context.Context, query ImageQuery) (ImagePage, error)
+	Get(ctx context.Context, id string) (Image, error)
+	// Create fails with errAlreadyExists if the image is already known.
+	Create(ctx context.Context, image Image) error
+	Delete(ctx context.Context, id string) error
+}
+
+// Store groups the repositories used by the handlers.
+type Store struct {
+	Cards     CardRepository
+	Favorites FavoriteRepository
+	Cart      CartRepository
+	Orders    OrderRepository
+	Users     UserRepository
+	Images    ImageRepository
+
+	close func(ctx context.Context) error
+}
+
+func (s Store) Close(ctx context.Context) error {
+	if s.close == nil {
+		return nil
+	}
+
+	return s.close(ctx)
+}
+
+func openStore(cfg Config) (Store, error) {
+	switch cfg.Store {
+	case storeMongo:
+		return newMongoStore(cfg.MongoURI, cfg.MongoDatabase)
+	case storeMemory:
+		return newMemoryStore(cfg.StoreFile)
+	default:
+		return Store{}, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+}
@@ -0,0 +1,623 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryData is the whole in-memory dataset; it is also the layout of the JSON snapshot file.
+type memoryData struct {
+	Cards []Card `json:"cards"`
+	// Favorites and Cart are keyed by user id.
+	Favorites map[string][]Card     `json:"favorites"`
+	Cart      map[string][]CartItem `json:"cart"`
+	Orders    []Order               `json:"orders"`
+	Users     []memoryUser          `json:"users"`
+	Images    []Image               `json:"images"`
+}
+
+// memoryUser keeps the password hash in the snapshot file, which User hides from JSON.
+type memoryUser struct {
+	User
+	PasswordHash string `json:"password_hash"`
+}
+
+// memoryStore keeps everything in process memory. When path is set, the dataset is loaded
+// from it on startup and written back after every change.
+type memoryStore struct {
+	mu   sync.RWMutex
+	path string
+	data memoryData
+}
+
+func newMemoryStore(path string) (Store, error) {
+	store := &memoryStore{path: path}
+	if err := store.load(); err != nil {
+		return Store{}, err
+	}
+
+	return Store{
+		Cards:     memoryCards{store},
+		Favorites: memoryFavorites{store},
+		Cart:      memoryCart{store},
+		Orders:    memoryOrders{store},
+		Users:     memoryUsers{store},
+		Images:    memoryImages{store},
+	}, nil
+}
+
+func (s *memoryStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, &s.data)
+}
+
+// save writes data as the snapshot to a temporary file and renames it over the old one,
+// so a crash never leaves a half-written file behind. Callers must hold s.mu.
+func (s *memoryStore) save(data memoryData) error {
+	if s.path == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// read runs fn under the read lock.
+func (s *memoryStore) read(fn func(data *memoryData)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fn(&s.data)
+}
+
+// write runs fn under the write lock on a copy of the dataset. The copy replaces the dataset
+// only once fn succeeded and the snapshot is saved, so a failed write changes nothing.
+func (s *memoryStore) write(fn func(data *memoryData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.data.clone()
+	if err := fn(&next); err != nil {
+		return err
+	}
+
+	if err := s.save(next); err != nil {
+		return err
+	}
+
+	s.data = next
+	return nil
+}
+
+// clone copies the collections of d, deep enough for write: changes replace elements of the
+// collections and never modify anything the elements point to.
+func (d memoryData) clone() memoryData {
+	return memoryData{
+		Cards:     copyOf(d.Cards),
+		Favorites: copyMap(d.Favorites),
+		Cart:      copyMap(d.Cart),
+		Orders:    copyOf(d.Orders),
+		Users:     copyOf(d.Users),
+		Images:    copyOf(d.Images),
+	}
+}
+
+func indexOfCard(cards []Card, id string) int {
+	for i, card := range cards {
+		if card.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func removeCard(cards *[]Card, id string) bool {
+	i := indexOfCard(*cards, id)
+	if i < 0 {
+		return false
+	}
+
+	*cards = append((*cards)[:i], (*cards)[i+1:]...)
+	return true
+}
+
+func copyOf[T any](items []T) []T {
+	return append(make([]T, 0, len(items)), items...)
+}
+
+func copyMap[T any](lists map[string][]T) map[string][]T {
+	if lists == nil {
+		return nil
+	}
+
+	copied := make(map[string][]T, len(lists))
+	for key, items := range lists {
+		copied[key] = copyOf(items)
+	}
+	return copied
+}
+
+type memoryCards struct {
+	store *memoryStore
+}
+
+func (r memoryCards) Find(_ context.Context, query CardQuery) (CardPage, error) {
+	var cards []Card
+	r.store.read(func(data *memoryData) {
+		for _, card := range data.Cards {
+			if query.matches(card) {
+				cards = append(cards, card)
+			}
+		}
+	})
+
+	sort.SliceStable(cards, func(i, j int) bool {
+		return query.compare(cards[i], query.sortKey(cards[j])) < 0
+	})
+
+	return query.paginate(cards), nil
+}
+
+func (r memoryCards) Get(_ context.Context, id string) (card Card, err error) {
+	r.store.read(func(data *memoryData) {
+		i := indexOfCard(data.Cards, id)
+		if i < 0 {
+			err = errNotFound
+			return
+		}
+
+		card = data.Cards[i]
+	})
+
+	return card, err
+}
+
+func (r memoryCards) Search(_ context.Context, text string, limit int) (hits []SearchHit, err error) {
+	r.store.read(func(data *memoryData) {
+		hits = searchCards(data.Cards, text, limit)
+	})
+
+	return hits, nil
+}
+
+func (r memoryCards) Create(_ context.Context, card Card) error {
+	return r.store.write(func(data *memoryData) error {
+		if indexOfCard(data.Cards, card.ID) >= 0 {
+			return errAlreadyExists
+		}
+
+		data.Cards = append(data.Cards, card)
+		return nil
+	})
+}
+
+func (r memoryCards) Update(_ context.Context, card Card) error {
+	return r.store.write(func(data *memoryData) error {
+		i := indexOfCard(data.Cards, card.ID)
+		if i < 0 {
+			return errNotFound
+		}
+
+		data.Cards[i] = card
+		for _, list := range data.Favorites {
+			if j := indexOfCard(list, card.ID); j >= 0 {
+				list[j] = card
+			}
+		}
+		for _, items := range data.Cart {
+			if j := indexOfItem(items, card.ID); j >= 0 {
+				items[j].Card = card
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r memoryCards) UsesImage(_ context.Context, imageID string) (used bool, err error) {
+	r.store.read(func(data *memoryData) {
+		for _, card := range data.Cards {
+			if imageKey(card.Img) == imageID {
+				used = true
+				return
+			}
+		}
+	})
+
+	return used, nil
+}
+
+func (r memoryCards) Delete(_ context.Context, id string) error {
+	return r.store.write(func(data *memoryData) error {
+		if !removeCard(&data.Cards, id) {
+			return errNotFound
+		}
+
+		for userID, list := range data.Favorites {
+			if removeCard(&list, id) {
+				data.Favorites[userID] = list
+			}
+		}
+		for userID, items := range data.Cart {
+			if i := indexOfItem(items, id); i >= 0 {
+				data.Cart[userID] = append(items[:i], items[i+1:]...)
+			}
+		}
+
+		return nil
+	})
+}
+
+type memoryFavorites struct {
+	store *memoryStore
+}
+
+func (r memoryFavorites) All(_ context.Context, userID string) (cards []Card, err error) {
+	r.store.read(func(data *memoryData) {
+		cards = copyOf(data.Favorites[userID])
+	})
+
+	return cards, nil
+}
+
+func (r memoryFavorites) Add(_ context.Context, userID string, card Card) error {
+	return r.store.write(func(data *memoryData) error {
+		if data.Favorites == nil {
+			data.Favorites = make(map[string][]Card)
+		}
+
+		if indexOfCard(data.Favorites[userID], card.ID) >= 0 {
+			return errAlreadyExists
+		}
+
+		data.Favorites[userID] = append(data.Favorites[userID], card)
+		return nil
+	})
+}
+
+func (r memoryFavorites) Delete(_ context.Context, userID, id string) error {
+	return r.store.write(func(data *memoryData) error {
+		list := data.Favorites[userID]
+		if !removeCard(&list, id) {
+			return errNotFound
+		}
+
+		data.Favorites[userID] = list
+		return nil
+	})
+}
+
+func indexOfItem(items []CartItem, id string) int {
+	for i, item := range items {
+		if item.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+type memoryCart struct {
+	store *memoryStore
+}
+
+func (r memoryCart) All(_ context.Context, userID string) (items []CartItem, err error) {
+	r.store.read(func(data *memoryData) {
+		items = copyOf(data.Cart[userID])
+	})
+
+	return items, nil
+}
+
+func (r memoryCart) Add(_ context.Context, userID string, card Card, quantity int) (item CartItem, err error) {
+	err = r.store.write(func(data *memoryData) error {
+		if data.Cart == nil {
+			data.Cart = make(map[string][]CartItem)
+		}
+
+		items := data.Cart[userID]
+		i := indexOfItem(items, card.ID)
+		if i < 0 {
+			items = append(items, CartItem{Card: card})
+			i = len(items) - 1
+		}
+
+		if items[i].Quantity+quantity > maxCartQuantity {
+			return errQuantityLimit
+		}
+
+		items[i].Quantity += quantity
+		data.Cart[userID] = items
+		item = items[i]
+		return nil
+	})
+
+	return item, err
+}
+
+// update sets the quantity of a line to change(current quantity), removing it at zero.
+func (r memoryCart) update(userID, id string, change func(quantity int) int) error {
+	return r.store.write(func(data *memoryData) error {
+		items := data.Cart[userID]
+		i := indexOfItem(items, id)
+		if i < 0 {
+			return errNotFound
+		}
+
+		quantity := change(items[i].Quantity)
+		switch {
+		case quantity > maxCartQuantity:
+			return errQuantityLimit
+		case quantity <= 0:
+			data.Cart[userID] = append(items[:i], items[i+1:]...)
+		default:
+			items[i].Quantity = quantity
+		}
+
+		return nil
+	})
+}
+
+func (r memoryCart) Adjust(_ context.Context, userID, id string, delta int) error {
+	// Larger deltas exceed the limit for any line, and could overflow the sum.
+	if delta > maxCartQuantity {
+		return errQuantityLimit
+	}
+
+	return r.update(userID, id, func(quantity int) int { return quantity + delta })
+}
+
+func (r memoryCart) SetQuantity(_ context.Context, userID, id string, quantity int) error {
+	return r.update(userID, id, func(int) int { return quantity })
+}
+
+func (r memoryCart) Delete(_ context.Context, userID, id string) error {
+	return r.update(userID, id, func(int) int { return 0 })
+}
+
+func (r memoryCart) Clear(_ context.Context, userID string) error {
+	return r.store.write(func(data *memoryData) error {
+		delete(data.Cart, userID)
+		return nil
+	})
+}
+
+type memoryOrders struct {
+	store *memoryStore
+}
+
+func (r memoryOrders) Find(_ context.Context, userID string, query OrderQuery) (OrderPage, error) {
+	var orders []Order
+	r.store.read(func(data *memoryData) {
+		for _, order := range data.Orders {
+			if order = normalizeOrder(order); order.UserID == userID && query.matches(order) {
+				orders = append(orders, order)
+			}
+		}
+	})
+
+	sort.SliceStable(orders, func(i, j int) bool {
+		return newerOrder(orders[i], orders[j])
+	})
+
+	return query.paginate(orders), nil
+}
+
+func indexOfOrder(orders []Order, id string) int {
+	for i, order := range orders {
+		if order.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (r memoryOrders) Get(_ context.Context, id string) (order Order, err error) {
+	r.store.read(func(data *memoryData) {
+		i := indexOfOrder(data.Orders, id)
+		if i < 0 {
+			err = errNotFound
+			return
+		}
+
+		order = normalizeOrder(data.Orders[i])
+	})
+
+	return order, err
+}
+
+func (r memoryOrders) SetStatus(_ context.Context, id, from, to string, at time.Time) error {
+	return r.store.write(func(data *memoryData) error {
+		i := indexOfOrder(data.Orders, id)
+		if i < 0 {
+			return errNotFound
+		}
+
+		if normalizeOrder(data.Orders[i]).Status != from {
+			return errInvalidTransition
+		}
+
+		data.Orders[i].Status, data.Orders[i].UpdatedAt = to, at
+		return nil
+	})
+}
+
+func (r memoryOrders) Create(_ context.Context, order Order) error {
+	return r.store.write(func(data *memoryData) error {
+		data.Orders = append(data.Orders, order)
+		return nil
+	})
+}
+
+// Checkout holds the store lock for the whole operation, which makes it atomic.
+func (r memoryOrders) Checkout(_ context.Context, userID string, build func(items []CartItem) Order) (order Order, err error) {
+	err = r.store.write(func(data *memoryData) error {
+		items := data.Cart[userID]
+		if len(items) == 0 {
+			return errEmptyCart
+		}
+
+		order = build(copyOf(items))
+		data.Orders = append(data.Orders, order)
+		delete(data.Cart, userID)
+		return nil
+	})
+
+	return order, err
+}
+
+type memoryUsers struct {
+	store *memoryStore
+}
+
+func (r memoryUsers) find(match func(user User) bool) (user User, err error) {
+	r.store.read(func(data *memoryData) {
+		for _, stored := range data.Users {
+			if match(stored.User) {
+				user = stored.User
+				user.PasswordHash = stored.PasswordHash
+				return
+			}
+		}
+
+		err = errNotFound
+	})
+
+	return user, err
+}
+
+func (r memoryUsers) Create(_ context.Context, user User) error {
+	return r.store.write(func(data *memoryData) error {
+		for _, stored := range data.Users {
+			if stored.ID == user.ID || stored.Email == user.Email {
+				return errAlreadyExists
+			}
+		}
+
+		data.Users = append(data.Users, memoryUser{User: user, PasswordHash: user.PasswordHash})
+		return nil
+	})
+}
+
+func (r memoryUsers) Get(_ context.Context, id string) (User, error) {
+	return r.find(func(user User) bool { return user.ID == id })
+}
+
+func (r memoryUsers) GetByEmail(_ context.Context, email string) (User, error) {
+	return r.find(func(user User) bool { return user.Email == email })
+}
+
+func (r memoryUsers) SetRole(_ context.Context, id, role string) error {
+	return r.store.write(func(data *memoryData) error {
+		for i := range data.Users {
+			if data.Users[i].ID == id {
+				data.Users[i].Role = role
+				return nil
+			}
+		}
+
+		return errNotFound
+	})
+}
+
+type memoryImages struct {
+	store *memoryStore
+}
+
+func indexOfImage(images []Image, id string) int {
+	for i, image := range images {
+		if image.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (r memoryImages) Find(_ context.Context, query ImageQuery) (ImagePage, error) {
+	var images []Image
+	r.store.read(func(data *memoryData) {
+		images = copyOf(data.Images)
+	})
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return newerImage(images[i], images[j])
+	})
+
+	return query.paginate(images), nil
+}
+
+func (r memoryImages) Get(_ context.Context, id string) (image Image, err error) {
+	r.store.read(func(data *memoryData) {
+		i := indexOfImage(data.Images, id)
+		if i < 0 {
+			err = errNotFound
+			return
+		}
+
+		image = data.Images[i]
+	})
+
+	return image, err
+}
+
+func (r memoryImages) Create(_ context.Context, image Image) error {
+	return r.store.write(func(data *memoryData) error {
+		if indexOfImage(data.Images, image.ID) >= 0 {
+			return errAlreadyExists
+		}
+
+		data.Images = append(data.Images, image)
+		return nil
+	})
+}
+
+func (r memoryImages) Delete(_ context.Context, id string) error {
+	return r.store.write(func(data *memoryData) error {
+		i := indexOfImage(data.Images, id)
+		if i < 0 {
+			return errNotFound
+		}
+
+		data.Images = append(data.Images[:i], data.Images[i+1:]...)
+		return nil
+	})
+}
@@ -0,0 +1,731 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	cardsCollectionName     = "cards"
+	cartCollectionName      = "cart"
+	favoritesCollectionName = "favorites"
+	ordersCollectionName    = "orders"
+	usersCollectionName     = "users"
+	imagesCollectionName    = "image_meta"
+)
+
+func ping(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+
+	if err := client.Ping(context.Background(), nil); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func newMongoStore(uri, database string) (Store, error) {
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		return Store{}, err
+	}
+
+	if err = ping(client); err != nil {
+		return Store{}, err
+	}
+
+	db := client.Database(database)
+	if err = ensureIndexes(db); err != nil {
+		return Store{}, err
+	}
+
+	return Store{
+		Cards:     mongoCards{db},
+		Favorites: mongoFavorites{db.Collection(favoritesCollectionName)},
+		Cart:      mongoCart{db.Collection(cartCollectionName)},
+		Orders:    mongoOrders{db},
+		Users:     mongoUsers{db.Collection(usersCollectionName)},
+		Images:    mongoImages{db.Collection(imagesCollectionName)},
+		close:     client.Disconnect,
+	}, nil
+}
+
+func ensureIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := map[string][]mongo.IndexModel{
+		cardsCollectionName: {{
+			Keys:    bson.D{{Key: "name", Value: "text"}},
+			Options: options.Index().SetName("name_text").SetDefaultLanguage("russian"),
+		}},
+		favoritesCollectionName: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "card._id", Value: 1}}},
+		},
+		cartCollectionName: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+			{Keys: bson.D{{Key: "card._id", Value: 1}}},
+		},
+		ordersCollectionName: {
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "cards._id", Value: 1}}},
+		},
+		usersCollectionName: {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		imagesCollectionName: {
+			{Keys: bson.D{{Key: "uploaded_at", Value: -1}, {Key: "_id", Value: 1}}},
+		},
+	}
+
+	for name, models := range indexes {
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, models); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []T
+	if err = cursor.All(ctx, &data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func insertOne(ctx context.Context, collection *mongo.Collection, document interface{}) error {
+	_, err := collection.InsertOne(ctx, document)
+	if mongo.IsDuplicateKeyError(err) {
+		return errAlreadyExists
+	}
+
+	return err
+}
+
+func deleteByID(ctx context.Context, collection *mongo.Collection, id string) error {
+	result, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errNotFound
+	}
+
+	return nil
+}
+
+type mongoCards struct {
+	db *mongo.Database
+}
+
+// copies returns the collections that embed their own copy of a card.
+func (r mongoCards) copies() []*mongo.Collection {
+	return []*mongo.Collection{
+		r.db.Collection(favoritesCollectionName),
+		r.db.Collection(cartCollectionName),
+	}
+}
+
+// cardFieldKeys maps sort field names to document keys.
+var cardFieldKeys = map[string]string{"id": "_id", "name": "name", "price": "price"}
+
+func (r mongoCards) Find(ctx context.Context, query CardQuery) (CardPage, error) {
+	collection := r.db.Collection(cardsCollectionName)
+
+	filter := bson.D{}
+	if query.Name != "" {
+		filter = append(filter, bson.E{Key: "name", Value: primitive.Regex{Pattern: regexp.QuoteMeta(query.Name), Options: "i"}})
+	}
+
+	price := bson.D{}
+	if query.MinPrice != nil {
+		price = append(price, bson.E{Key: "$gte", Value: *query.MinPrice})
+	}
+	if query.MaxPrice != nil {
+		price = append(price, bson.E{Key: "$lte", Value: *query.MaxPrice})
+	}
+	if len(price) > 0 {
+		filter = append(filter, bson.E{Key: "price", Value: price})
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return CardPage{}, err
+	}
+
+	if query.After != nil {
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, afterFilter(query.Sort, query.After.Values)}}}
+	}
+
+	sort := bson.D{}
+	for _, field := range query.Sort {
+		direction := 1
+		if field.Desc {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: cardFieldKeys[field.Name], Value: direction})
+	}
+
+	opts := options.Find().SetSort(sort).SetSkip(int64(query.Offset))
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit) + 1)
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return CardPage{}, err
+	}
+
+	var cards []Card
+	if err = cursor.All(ctx, &cards); err != nil {
+		return CardPage{}, err
+	}
+
+	page := CardPage{Cards: cards, Total: total}
+	if query.Limit > 0 && len(cards) > query.Limit {
+		page.Cards = cards[:query.Limit]
+		page.NextCursor = encodeCursor(page.Cards[query.Limit-1], query.Sort)
+	}
+
+	return page, nil
+}
+
+// afterFilter matches documents that sort strictly after values:
+// (f1 > v1) OR (f1 = v1 AND f2 > v2) OR ..., with ">" flipped to "<" for descending fields.
+func afterFilter(fields []sortField, values []interface{}) bson.D {
+	var branches bson.A
+	for i, field := range fields {
+		branch := bson.D{}
+		for j := 0; j < i; j++ {
+			branch = append(branch, bson.E{Key: cardFieldKeys[fields[j].Name], Value: values[j]})
+		}
+
+		operator := "$gt"
+		if field.Desc {
+			operator = "$lt"
+		}
+		branch = append(branch, bson.E{Key: cardFieldKeys[field.Name], Value: bson.D{{Key: operator, Value: values[i]}}})
+
+		branches = append(branches, branch)
+	}
+
+	return bson.D{{Key: "$or", Value: branches}}
+}
+
+func (r mongoCards) Get(ctx context.Context, id string) (Card, error) {
+	var card Card
+	err := r.db.Collection(cardsCollectionName).FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&card)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Card{}, errNotFound
+	}
+
+	return card, err
+}
+
+// Search ranks cards with the text index on name. When the index finds nothing, typically
+// because of a typo, it falls back to the in-memory fuzzy matcher over the whole catalogue.
+func (r mongoCards) Search(ctx context.Context, text string, limit int) ([]SearchHit, error) {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	score := bson.D{{Key: "$meta", Value: "textScore"}}
+	opts := options.Find().
+		SetProjection(bson.D{{Key: "score", Value: score}}).
+		SetSort(bson.D{{Key: "score", Value: score}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	filter := bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: text}}}}
+	documents, err := findAll[struct {
+		Card  `bson:",inline"`
+		Score float64 `bson:"score"`
+	}](ctx, r.db.Collection(cardsCollectionName), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(documents) == 0 {
+		cards, err := findAll[Card](ctx, r.db.Collection(cardsCollectionName), bson.D{})
+		if err != nil {
+			return nil, err
+		}
+
+		return searchCards(cards, text, limit), nil
+	}
+
+	hits := make([]SearchHit, 0, len(documents))
+	for _, document := range documents {
+		hits = append(hits, SearchHit{
+			Card:      document.Card,
+			Score:     document.Score,
+			Highlight: highlight(document.Name, terms, false),
+		})
+	}
+
+	return hits, nil
+}
+
+func (r mongoCards) Create(ctx context.Context, card Card) error {
+	return insertOne(ctx, r.db.Collection(cardsCollectionName), card)
+}
+
+func (r mongoCards) Update(ctx context.Context, card Card) error {
+	filter := bson.D{{Key: "_id", Value: card.ID}}
+	result, err := r.db.Collection(cardsCollectionName).ReplaceOne(ctx, filter, card)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "card", Value: card}}}}
+	for _, collection := range r.copies() {
+		if _, err = collection.UpdateMany(ctx, bson.D{{Key: "card._id", Value: card.ID}}, update); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UsesImage also recognises cards saved before images were stored as ids, whose img is a
+// full link ending with the id.
+func (r mongoCards) UsesImage(ctx context.Context, imageID string) (bool, error) {
+	filter := bson.D{{Key: "img", Value: bson.D{{Key: "$in", Value: bson.A{
+		imageID,
+		primitive.Regex{Pattern: regexp.QuoteMeta(storagePath+url.PathEscape(imageID)) + "$"},
+	}}}}}
+
+	count, err := r.db.Collection(cardsCollectionName).CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+func (r mongoCards) Delete(ctx context.Context, id string) error {
+	if err := deleteByID(ctx, r.db.Collection(cardsCollectionName), id); err != nil {
+		return err
+	}
+
+	filter := bson.D{{Key: "card._id", Value: id}}
+	for _, collection := range r.copies() {
+		if _, err := collection.DeleteMany(ctx, filter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cardEntryDocument is a card saved in a user's favorites or cart. The _id combines the user
+// and the card, so a user cannot add the same card twice. Quantity is only used by the cart.
+type cardEntryDocument struct {
+	ID       string `bson:"_id"`
+	UserID   string `bson:"user_id"`
+	Card     Card   `bson:"card"`
+	Quantity int    `bson:"quantity,omitempty"`
+}
+
+func cardEntryID(userID, cardID string) string {
+	return userID + "/" + cardID
+}
+
+type mongoFavorites struct {
+	collection *mongo.Collection
+}
+
+func (r mongoFavorites) All(ctx context.Context, userID string) ([]Card, error) {
+	documents, err := findAll[cardEntryDocument](ctx, r.collection, bson.D{{Key: "user_id", Value: userID}})
+	if err != nil {
+		return nil, err
+	}
+
+	cards := make([]Card, 0, len(documents))
+	for _, document := range documents {
+		cards = append(cards, document.Card)
+	}
+
+	return cards, nil
+}
+
+func (r mongoFavorites) Add(ctx context.Context, userID string, card Card) error {
+	return insertOne(ctx, r.collection, cardEntryDocument{
+		ID:     cardEntryID(userID, card.ID),
+		UserID: userID,
+		Card:   card,
+	})
+}
+
+func (r mongoFavorites) Delete(ctx context.Context, userID, id string) error {
+	return deleteByID(ctx, r.collection, cardEntryID(userID, id))
+}
+
+type mongoCart struct {
+	collection *mongo.Collection
+}
+
+func (r mongoCart) All(ctx context.Context, userID string) ([]CartItem, error) {
+	documents, err := findAll[cardEntryDocument](ctx, r.collection, bson.D{{Key: "user_id", Value: userID}})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]CartItem, 0, len(documents))
+	for _, document := range documents {
+		items = append(items, CartItem{Card: document.Card, Quantity: document.Quantity})
+	}
+
+	return items, nil
+}
+
+func (r mongoCart) Add(ctx context.Context, userID string, card Card, quantity int) (CartItem, error) {
+	id := cardEntryID(userID, card.ID)
+	filter := bson.D{
+		{Key: "_id", Value: id},
+		{Key: "quantity", Value: bson.D{{Key: "$lte", Value: maxCartQuantity - quantity}}},
+	}
+	update := bson.D{
+		{Key: "$setOnInsert", Value: bson.D{{Key: "user_id", Value: userID}, {Key: "card", Value: card}}},
+		{Key: "$inc", Value: bson.D{{Key: "quantity", Value: quantity}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var document cardEntryDocument
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&document)
+	if mongo.IsDuplicateKeyError(err) {
+		// The line exists but the quantity filter did not match it, so the upsert collided with it.
+		return CartItem{}, errQuantityLimit
+	}
+	if err != nil {
+		return CartItem{}, err
+	}
+
+	return CartItem{Card: document.Card, Quantity: document.Quantity}, nil
+}
+
+func (r mongoCart) Adjust(ctx context.Context, userID, id string, delta int) error {
+	// Larger deltas exceed the limit for any line, and could overflow maxCartQuantity - delta.
+	if delta > maxCartQuantity {
+		return errQuantityLimit
+	}
+
+	filter := bson.D{{Key: "_id", Value: cardEntryID(userID, id)}}
+	if delta > 0 {
+		filter = append(filter, bson.E{Key: "quantity", Value: bson.D{{Key: "$lte", Value: maxCartQuantity - delta}}})
+	}
+
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "quantity", Value: delta}}}}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		count, err := r.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: cardEntryID(userID, id)}})
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return errQuantityLimit
+		}
+		return errNotFound
+	}
+
+	return r.removeEmpty(ctx, userID, id)
+}
+
+func (r mongoCart) SetQuantity(ctx context.Context, userID, id string, quantity int) error {
+	if quantity > maxCartQuantity {
+		return errQuantityLimit
+	}
+
+	if quantity <= 0 {
+		return r.Delete(ctx, userID, id)
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "quantity", Value: quantity}}}}
+	result, err := r.collection.UpdateByID(ctx, cardEntryID(userID, id), update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+
+	return nil
+}
+
+// removeEmpty deletes the line if its quantity dropped to zero.
+func (r mongoCart) removeEmpty(ctx context.Context, userID, id string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: cardEntryID(userID, id)},
+		{Key: "quantity", Value: bson.D{{Key: "$lte", Value: 0}}},
+	})
+
+	return err
+}
+
+func (r mongoCart) Delete(ctx context.Context, userID, id string) error {
+	return deleteByID(ctx, r.collection, cardEntryID(userID, id))
+}
+
+func (r mongoCart) Clear(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.D{{Key: "user_id", Value: userID}})
+	return err
+}
+
+type mongoOrders struct {
+	db *mongo.Database
+}
+
+func (r mongoOrders) Find(ctx context.Context, userID string, query OrderQuery) (OrderPage, error) {
+	collection := r.db.Collection(ordersCollectionName)
+
+	filter := bson.D{{Key: "user_id", Value: userID}}
+
+	created := bson.D{}
+	if query.From != nil {
+		created = append(created, bson.E{Key: "$gte", Value: *query.From})
+	}
+	if query.To != nil {
+		created = append(created, bson.E{Key: "$lt", Value: *query.To})
+	}
+	if len(created) > 0 {
+		filter = append(filter, bson.E{Key: "created_at", Value: created})
+	}
+
+	if len(query.Statuses) > 0 {
+		statuses := bson.A{}
+		for _, status := range query.Statuses {
+			statuses = append(statuses, status)
+		}
+		if contains(query.Statuses, orderPending) {
+			// Orders stored before statuses existed have none and count as pending.
+			statuses = append(statuses, nil)
+		}
+		filter = append(filter, bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: statuses}}})
+	}
+
+	total := bson.D{}
+	if query.MinTotal != nil {
+		total = append(total, bson.E{Key: "$gte", Value: *query.MinTotal})
+	}
+	if query.MaxTotal != nil {
+		total = append(total, bson.E{Key: "$lte", Value: *query.MaxTotal})
+	}
+	if len(total) > 0 {
+		filter = append(filter, bson.E{Key: "total", Value: total})
+	}
+
+	if query.CardID != "" {
+		filter = append(filter, bson.E{Key: "cards._id", Value: query.CardID})
+	}
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return OrderPage{}, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(query.Offset))
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	orders, err := findAll[Order](ctx, collection, filter, opts)
+	if err != nil {
+		return OrderPage{}, err
+	}
+
+	for i := range orders {
+		orders[i] = normalizeOrder(orders[i])
+	}
+
+	return OrderPage{Orders: orders, Total: count}, nil
+}
+
+func (r mongoOrders) Get(ctx context.Context, id string) (Order, error) {
+	var order Order
+	err := r.db.Collection(ordersCollectionName).FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Order{}, errNotFound
+	}
+
+	return normalizeOrder(order), err
+}
+
+func (r mongoOrders) SetStatus(ctx context.Context, id, from, to string, at time.Time) error {
+	current := interface{}(from)
+	if from == orderPending {
+		// Orders stored before statuses existed have none and count as pending.
+		current = bson.D{{Key: "$in", Value: bson.A{orderPending, nil}}}
+	}
+
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "status", Value: current}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: to}, {Key: "updated_at", Value: at}}}}
+	result, err := r.db.Collection(ordersCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errInvalidTransition
+	}
+
+	return nil
+}
+
+func (r mongoOrders) Create(ctx context.Context, order Order) error {
+	return insertOne(ctx, r.db.Collection(ordersCollectionName), order)
+}
+
+// Checkout runs in a multi-document transaction, which needs MongoDB running as a replica set.
+func (r mongoOrders) Checkout(ctx context.Context, userID string, build func(items []CartItem) Order) (Order, error) {
+	session, err := r.db.Client().StartSession()
+	if err != nil {
+		return Order{}, err
+	}
+	defer session.EndSession(ctx)
+
+	result, err := session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		cart := r.db.Collection(cartCollectionName)
+		filter := bson.D{{Key: "user_id", Value: userID}}
+
+		items, err := mongoCart{cart}.All(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(items) == 0 {
+			return nil, errEmptyCart
+		}
+
+		order := build(items)
+		if err = insertOne(ctx, r.db.Collection(ordersCollectionName), order); err != nil {
+			return nil, err
+		}
+
+		if _, err = cart.DeleteMany(ctx, filter); err != nil {
+			return nil, err
+		}
+
+		return order, nil
+	})
+	if err != nil {
+		return Order{}, err
+	}
+
+	return result.(Order), nil
+}
+
+type mongoUsers struct {
+	collection *mongo.Collection
+}
+
+func (r mongoUsers) findOne(ctx context.Context, filter bson.D) (User, error) {
+	var user User
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, errNotFound
+	}
+
+	return user, err
+}
+
+func (r mongoUsers) Create(ctx context.Context, user User) error {
+	return insertOne(ctx, r.collection, user)
+}
+
+func (r mongoUsers) Get(ctx context.Context, id string) (User, error) {
+	return r.findOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (r mongoUsers) GetByEmail(ctx context.Context, email string) (User, error) {
+	return r.findOne(ctx, bson.D{{Key: "email", Value: email}})
+}
+
+func (r mongoUsers) SetRole(ctx context.Context, id, role string) error {
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}}
+	result, err := r.collection.UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errNotFound
+	}
+
+	return nil
+}
+
+type mongoImages struct {
+	collection *mongo.Collection
+}
+
+func (r mongoImages) Find(ctx context.Context, query ImageQuery) (ImagePage, error) {
+	total, err := r.collection.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return ImagePage{}, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "uploaded_at", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(query.Offset))
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	images, err := findAll[Image](ctx, r.collection, bson.D{}, opts)
+	if err != nil {
+		return ImagePage{}, err
+	}
+
+	return ImagePage{Images: images, Total: total}, nil
+}
+
+func (r mongoImages) Get(ctx context.Context, id string) (Image, error) {
+	var image Image
+	err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&image)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Image{}, errNotFound
+	}
+
+	return image, err
+}
+
+func (r mongoImages) Create(ctx context.Context, image Image) error {
+	return insertOne(ctx, r.collection, image)
+}
+
+func (r mongoImages) Delete(ctx context.Context, id string) error {
+	return deleteByID(ctx, r.collection, id)
+}
@@ -1,45 +1,111 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 )
 
-func writeJSON(code int, writer http.ResponseWriter, data interface{}) {
-	response, err := json.Marshal(data)
-	if err != nil {
-		log.Println(err)
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+func handleRequest(writer http.ResponseWriter, request *http.Request, data interface{}) bool {
+	return decodeRequest(writer, request, data, bodyMediaTypes...)
+}
 
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(code)
-	_, err = writer.Write(response)
-	if err != nil {
-		log.Println(err)
-		writer.WriteHeader(http.StatusInternalServerError)
-		return
+// decodeRequest decodes a body whose media type is one of mediaTypes and checks it against
+// the `validate` tags of data.
+func decodeRequest(writer http.ResponseWriter, request *http.Request, data interface{}, mediaTypes ...string) bool {
+	mediaType, params, err := parseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || !contains(mediaTypes, mediaType) {
+		writeProblem(writer, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+			"Content-Type must be one of "+strings.Join(mediaTypes, ", "))
+		return false
 	}
-}
 
-func handleRequest(writer http.ResponseWriter, request *http.Request, data interface{}) bool {
-	if request.Header.Get("Content-Type") != "application/json" {
-		writer.WriteHeader(http.StatusUnsupportedMediaType)
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		writeProblem(writer, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "only UTF-8 bodies are supported")
 		return false
 	}
 
-	if err := json.NewDecoder(request.Body).Decode(data); err != nil {
-		log.Println(err)
-		writer.WriteHeader(http.StatusInternalServerError)
+	request.Body = http.MaxBytesReader(writer, request.Body, maxRequestBodySize)
+	body, err := readBody(request, mediaType, data)
+	if err == nil {
+		err = decodeJSON(body, data)
+	}
+	if err != nil {
+		writeBodyError(writer, err)
 		return false
 	}
-	if err := request.Body.Close(); err != nil {
+	if err = request.Body.Close(); err != nil {
 		log.Println(err)
-		writer.WriteHeader(http.StatusInternalServerError)
+	}
+
+	if fields := validateRequest(data); len(fields) > 0 {
+		writeValidationError(writer, fields...)
 		return false
 	}
 
 	return true
 }
+
+// writeStoreError maps repository errors to problem responses.
+func writeStoreError(writer http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		writeProblem(writer, http.StatusNotFound, codeNotFound, "")
+	case errors.Is(err, errAlreadyExists):
+		writeProblem(writer, http.StatusConflict, codeAlreadyExists, err.Error())
+	case errors.Is(err, errEmptyCart):
+		writeProblem(writer, http.StatusConflict, codeEmptyCart, err.Error())
+	case errors.Is(err, errQuantityLimit):
+		writeProblem(writer, http.StatusUnprocessableEntity, codeQuantityLimit, err.Error())
+	default:
+		writeInternalError(writer, err)
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, candidate := range items {
+		if candidate == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergePatch applies an RFC 7396 JSON merge patch to target. Both are values produced by
+// decoding JSON into interface{}.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// remarshal converts from into to through their JSON representation.
+func remarshal(from, to interface{}) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	return decodeJSON(bytes.NewReader(data), to)
+}
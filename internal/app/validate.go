@@ -0,0 +1,170 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// maxRequestBodySize limits JSON request bodies; uploads have their own max_upload_size.
+const maxRequestBodySize = 1 << 20
+
+// requestValidator checks the `validate` tags of request bodies. Fields are reported under
+// their JSON names.
+var requestValidator = newRequestValidator()
+
+func newRequestValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return field.Name
+		}
+		return name
+	})
+
+	// image accepts what Card.Img can hold: an uploaded image, by id or by link, or an absolute
+	// http(s) URL of an image elsewhere. Ids are the content-addressed names UploadImage gives.
+	if err := v.RegisterValidation("image", func(level validator.FieldLevel) bool {
+		img := level.Field().String()
+		key := imageKey(img)
+		if contentAddressedName.MatchString(key) {
+			return true
+		}
+		if key != img {
+			// A link into the storage that names no upload.
+			return false
+		}
+
+		parsed, err := url.Parse(img)
+		return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+	}); err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// validateRequest returns the fields of data that break its `validate` tags. Values other than
+// structs, such as decoded merge patches, are not checked.
+func validateRequest(data interface{}) []FieldError {
+	var invalid validator.ValidationErrors
+	if !errors.As(requestValidator.Struct(data), &invalid) {
+		return nil
+	}
+
+	fields := make([]FieldError, 0, len(invalid))
+	for _, err := range invalid {
+		// The namespace starts with the Go name of the request type.
+		_, path, _ := strings.Cut(err.Namespace(), ".")
+		fields = append(fields, FieldError{Field: path, Message: err.Field() + " " + constraintMessage(err)})
+	}
+
+	return fields
+}
+
+func constraintMessage(err validator.FieldError) string {
+	param := err.Param()
+	unit := ""
+	switch err.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+
+	switch err.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		if unit != "" && param == "1" {
+			return "must not be empty"
+		}
+		return "must be at least " + param + unit
+	case "max", "lte":
+		return "must be at most " + param + unit
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(param, " ", ", ")
+	case "required_without":
+		return "is required unless " + strings.ToLower(param) + " is given"
+	case "excluded_with":
+		return "must not be given together with " + strings.ToLower(param)
+	case "image":
+		return "must be the id or link of an uploaded image or an absolute http(s) URL"
+	default:
+		return "does not satisfy " + err.Tag()
+	}
+}
+
+// decodeJSON decodes a single JSON value into data, rejecting fields data does not have.
+func decodeJSON(reader io.Reader, data interface{}) error {
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(data); err != nil {
+		return err
+	}
+
+	if decoder.More() {
+		return errors.New("body must hold a single JSON value")
+	}
+
+	return nil
+}
+
+// writeBodyError answers a body that could not be decoded: 413 when it is too large, 400
+// otherwise, pointing at the offending field where the decoder names it.
+func writeBodyError(writer http.ResponseWriter, err error) {
+	var (
+		maxBytesErr *http.MaxBytesError
+		typeErr     *json.UnmarshalTypeError
+		field       FieldError
+	)
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeProblem(writer, http.StatusRequestEntityTooLarge, codePayloadTooLarge,
+			fmt.Sprintf("the body must not exceed %d bytes", maxBytesErr.Limit))
+	case errors.Is(err, io.EOF):
+		writeProblem(writer, http.StatusBadRequest, codeInvalidBody, "the body is empty")
+	case errors.As(err, &field):
+		writeProblem(writer, http.StatusBadRequest, codeInvalidBody, "malformed body", field)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		writeProblem(writer, http.StatusBadRequest, codeInvalidBody, "malformed body",
+			invalidField(typeErr.Field, typeErr.Field+" must be "+jsonKind(typeErr.Type)))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields.
+		name := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeProblem(writer, http.StatusBadRequest, codeInvalidBody, "malformed body", invalidField(name, "unknown field"))
+	default:
+		writeProblem(writer, http.StatusBadRequest, codeInvalidBody, "malformed body: "+err.Error())
+	}
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	default:
+		return "an object"
+	}
+}
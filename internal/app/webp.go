@@ -0,0 +1,360 @@
+package app
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// A minimal encoder of lossless WebP (VP8L). The standard library and golang.org/x/image
+// can only decode WebP. The encoder uses none of the VP8L transforms and no backward
+// references: every pixel is written as four Huffman-coded literals. That is larger than
+// what libwebp produces, but is still compressed and decodes in every browser.
+
+const (
+	vp8lSignature     = 0x2f
+	vp8lMaxDimension  = 1 << 14
+	vp8lGreenAlphabet = 256 + 24
+	vp8lDistAlphabet  = 40
+	maxCodeLength     = 15
+	maxCodeLengthCode = 7
+)
+
+// vp8lCodeLengthOrder is the order in which the code length code lengths are stored.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+type bitWriter struct {
+	w     io.Writer
+	bits  uint64
+	count uint
+	err   error
+}
+
+// write appends the n low bits of value, least significant bit first.
+func (b *bitWriter) write(value uint32, n uint) {
+	b.bits |= uint64(value) << b.count
+	b.count += n
+	for b.count >= 8 {
+		b.flushByte()
+	}
+}
+
+func (b *bitWriter) flushByte() {
+	if b.err == nil {
+		_, b.err = b.w.Write([]byte{byte(b.bits)})
+	}
+	b.bits >>= 8
+	if b.count >= 8 {
+		b.count -= 8
+	} else {
+		b.count = 0
+	}
+}
+
+func (b *bitWriter) close() error {
+	for b.count > 0 {
+		b.flushByte()
+	}
+	return b.err
+}
+
+// prefixCode is a canonical Huffman code.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func (c prefixCode) write(b *bitWriter, symbol int) {
+	length := c.lengths[symbol]
+	if length == 0 {
+		return
+	}
+
+	// Huffman codes are stored starting from their most significant bit.
+	code, reversed := c.codes[symbol], uint32(0)
+	for i := uint8(0); i < length; i++ {
+		reversed = reversed<<1 | uint32(code>>i&1)
+	}
+	b.write(reversed, uint(length))
+}
+
+// huffmanLengths returns code lengths for the symbol frequencies, none longer than maxLength.
+// Frequencies are flattened until the tree is shallow enough.
+func huffmanLengths(freqs []int, maxLength int) []uint8 {
+	freqs = append([]int(nil), freqs...)
+	for {
+		lengths := huffmanTree(freqs)
+
+		fits := true
+		for _, length := range lengths {
+			if int(length) > maxLength {
+				fits = false
+			}
+		}
+		if fits {
+			return lengths
+		}
+
+		for i, freq := range freqs {
+			if freq > 0 {
+				freqs[i] = (freq + 1) / 2
+			}
+		}
+	}
+}
+
+func huffmanTree(freqs []int) []uint8 {
+	type node struct {
+		weight      int
+		symbol      int
+		left, right int
+	}
+
+	var nodes []node
+	for symbol, freq := range freqs {
+		if freq > 0 {
+			nodes = append(nodes, node{weight: freq, symbol: symbol, left: -1, right: -1})
+		}
+	}
+
+	lengths := make([]uint8, len(freqs))
+	if len(nodes) < 2 {
+		for _, leaf := range nodes {
+			lengths[leaf.symbol] = 1
+		}
+		return lengths
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+
+	// Two-queue construction: leaves are taken from nodes in order, merged nodes are
+	// appended to nodes and taken in order from merged, both queues stay sorted.
+	leaves, leafCount, merged := 0, len(nodes), len(nodes)
+	take := func() int {
+		if leaves < leafCount && (merged >= len(nodes) || nodes[leaves].weight <= nodes[merged].weight) {
+			leaves++
+			return leaves - 1
+		}
+		merged++
+		return merged - 1
+	}
+
+	for i := 1; i < leafCount; i++ {
+		left, right := take(), take()
+		nodes = append(nodes, node{weight: nodes[left].weight + nodes[right].weight, symbol: -1, left: left, right: right})
+	}
+
+	var walk func(index int, depth uint8)
+	walk = func(index int, depth uint8) {
+		if nodes[index].left < 0 {
+			lengths[nodes[index].symbol] = depth
+			return
+		}
+		walk(nodes[index].left, depth+1)
+		walk(nodes[index].right, depth+1)
+	}
+	walk(len(nodes)-1, 0)
+
+	return lengths
+}
+
+// newPrefixCode assigns canonical codes to lengths, shorter codes and smaller symbols first.
+func newPrefixCode(lengths []uint8) prefixCode {
+	var counts [maxCodeLength + 1]uint16
+	for _, length := range lengths {
+		counts[length]++
+	}
+	counts[0] = 0
+
+	var next [maxCodeLength + 2]uint16
+	for length := 1; length <= maxCodeLength; length++ {
+		next[length+1] = (next[length] + counts[length]) << 1
+	}
+
+	codes := make([]uint16, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			codes[symbol] = next[length]
+			next[length]++
+		}
+	}
+
+	return prefixCode{lengths: lengths, codes: codes}
+}
+
+// writePrefixCode stores a prefix code for the histogram and returns it.
+func writePrefixCode(b *bitWriter, histogram []int) prefixCode {
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		// Simple code: one or two symbols stored directly.
+		if len(used) == 0 {
+			used = []int{0}
+		}
+
+		b.write(1, 1)
+		b.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			b.write(0, 1)
+			b.write(uint32(used[0]), 1)
+		} else {
+			b.write(1, 1)
+			b.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			b.write(uint32(used[1]), 8)
+		}
+
+		lengths := make([]uint8, len(histogram))
+		if len(used) == 2 {
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return newPrefixCode(lengths)
+	}
+
+	lengths := huffmanLengths(histogram, maxCodeLength)
+
+	// The code lengths are themselves Huffman-coded; only the literal lengths 0-15 are used.
+	lengthFreqs := make([]int, len(vp8lCodeLengthOrder))
+	for _, length := range lengths {
+		lengthFreqs[length]++
+	}
+	if nonZero := countNonZero(lengthFreqs); nonZero < 2 {
+		// A code needs two symbols to be a complete tree.
+		if lengthFreqs[0] == 0 {
+			lengthFreqs[0] = 1
+		} else {
+			lengthFreqs[1] = 1
+		}
+	}
+	lengthCode := newPrefixCode(huffmanLengths(lengthFreqs, maxCodeLengthCode))
+
+	stored := 4
+	for i, symbol := range vp8lCodeLengthOrder {
+		if lengthCode.lengths[symbol] > 0 && i+1 > stored {
+			stored = i + 1
+		}
+	}
+
+	b.write(0, 1)
+	b.write(uint32(stored-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:stored] {
+		b.write(uint32(lengthCode.lengths[symbol]), 3)
+	}
+
+	// Lengths are given for the whole alphabet.
+	b.write(0, 1)
+	for _, length := range lengths {
+		lengthCode.write(b, int(length))
+	}
+
+	return newPrefixCode(lengths)
+}
+
+func countNonZero(values []int) int {
+	count := 0
+	for _, value := range values {
+		if value != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// encodeWebP writes img as a lossless WebP.
+func encodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return errors.New("webp: image size out of range")
+	}
+
+	pixels := make([]color.NRGBA, 0, width*height)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixels = append(pixels, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+
+	green, red, blue, alpha := make([]int, vp8lGreenAlphabet), make([]int, 256), make([]int, 256), make([]int, 256)
+	hasAlpha := false
+	for _, pixel := range pixels {
+		green[pixel.G]++
+		red[pixel.R]++
+		blue[pixel.B]++
+		alpha[pixel.A]++
+		hasAlpha = hasAlpha || pixel.A != 0xff
+	}
+
+	var data sliceWriter
+	b := &bitWriter{w: &data}
+
+	b.write(vp8lSignature, 8)
+	b.write(uint32(width-1), 14)
+	b.write(uint32(height-1), 14)
+	if hasAlpha {
+		b.write(1, 1)
+	} else {
+		b.write(0, 1)
+	}
+	b.write(0, 3) // version
+
+	b.write(0, 1) // no transforms
+	b.write(0, 1) // no color cache
+	b.write(0, 1) // a single group of prefix codes
+
+	greenCode := writePrefixCode(b, green)
+	redCode := writePrefixCode(b, red)
+	blueCode := writePrefixCode(b, blue)
+	alphaCode := writePrefixCode(b, alpha)
+	writePrefixCode(b, make([]int, vp8lDistAlphabet))
+
+	for _, pixel := range pixels {
+		greenCode.write(b, int(pixel.G))
+		redCode.write(b, int(pixel.R))
+		blueCode.write(b, int(pixel.B))
+		alphaCode.write(b, int(pixel.A))
+	}
+
+	if err := b.close(); err != nil {
+		return err
+	}
+
+	chunk := data
+	padding := len(chunk) % 2
+
+	header := make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+len(chunk)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(chunk)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(chunk); err != nil {
+		return err
+	}
+	if padding > 0 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type sliceWriter []byte
+
+func (s *sliceWriter) Write(p []byte) (int, error) {
+	*s = append(*s, p...)
+	return len(p), nil
+}